@@ -16,6 +16,19 @@ func LineEnding() []byte {
 	return []byte(lineEnding)
 }
 
+// DetectLineEnding returns the line ending of the first line inside b.
+// If b does not contain a line ending, ok is false.
+func DetectLineEnding(b []byte) (le []byte, ok bool) {
+	idx := bytes.IndexByte(b, '\n')
+	if idx < 0 {
+		return
+	}
+	if idx > 0 && b[idx-1] == '\r' {
+		return []byte("\r\n"), true
+	}
+	return []byte("\n"), true
+}
+
 // GetLeadingWhitespace returns all leading whitespace characters.
 func GetLeadingWhitespace(line []byte) (s []byte) {
 	for _, r := range line {
@@ -97,3 +97,84 @@ func TestGetLeadingWhitespaceTabs(t *testing.T) {
 	s := GetLeadingWhitespace([]byte("\t\ttest"))
 	r.Exactly(t, []byte("\t\t"), s)
 }
+
+func TestDetectLineEnding(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		content  string
+		expected string
+		ok       bool
+	}
+
+	testCases := []testCase{
+		{content: ""},
+		{content: "no line ending"},
+		{content: "\r"},
+		{content: "\n", expected: "\n", ok: true},
+		{content: "\r\n", expected: "\r\n", ok: true},
+		{content: "lf\ncrlf\r\n", expected: "\n", ok: true},
+		{content: "crlf\r\nlf\n", expected: "\r\n", ok: true},
+	}
+
+	for i, tc := range testCases {
+		le, ok := DetectLineEnding([]byte(tc.content))
+		r.Exactly(t, tc.ok, ok, "case=%d", i)
+		r.Exactly(t, tc.expected, string(le), "case=%d", i)
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		name         string
+		expectedRoot string
+		expectedPath []string
+	}
+
+	testCases := []testCase{
+		{name: "name", expectedRoot: "name"},
+		{name: "db.host", expectedRoot: "db", expectedPath: []string{"host"}},
+		{name: "db.primary.host", expectedRoot: "db", expectedPath: []string{"primary", "host"}},
+		{name: "hosts[2]", expectedRoot: "hosts", expectedPath: []string{"2"}},
+		{name: "servers[0].ports[1]", expectedRoot: "servers", expectedPath: []string{"0", "ports", "1"}},
+		{name: ".host", expectedRoot: ".host"},
+		{name: "db..host", expectedRoot: "db..host"},
+		{name: "hosts[0", expectedRoot: "hosts[0"},
+		{name: "hosts[0]x", expectedRoot: "hosts[0]x"},
+	}
+
+	for i, tc := range testCases {
+		root, path := SplitPath(tc.name)
+		r.Exactly(t, tc.expectedRoot, root, "case=%d", i)
+		r.Exactly(t, tc.expectedPath, path, "case=%d", i)
+	}
+}
+
+func TestStructure(t *testing.T) {
+	t.Parallel()
+
+	db := NewMap("db",
+		NewVar("host", "localhost"),
+		NewList("ports", NewVar("", "5432"), NewVar("", "5433")),
+	)
+	r.Exactly(t, `{"host":"localhost","ports":["5432","5433"]}`, db.Value())
+
+	v, ok := Lookup(db, []string{"ports", "1"})
+	r.True(t, ok)
+	r.Exactly(t, "5433", v.Value())
+
+	v, ok = Lookup(db, []string{"host"})
+	r.True(t, ok)
+	r.Exactly(t, "localhost", v.Value())
+
+	_, ok = Lookup(db, []string{"host", "name"})
+	r.False(t, ok)
+	_, ok = Lookup(db, []string{"ports", "2"})
+	r.False(t, ok)
+
+	renamed := Rename(db, "value")
+	r.Exactly(t, "value", renamed.Name())
+	r.Exactly(t, db.Value(), renamed.Value())
+}
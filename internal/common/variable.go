@@ -2,6 +2,9 @@ package common
 
 import (
 	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
 )
 
 type variable struct {
@@ -41,3 +44,153 @@ func VarFromArg(arg []byte) (_ variable) {
 		value: string(TrimQuotes(bytes.TrimSpace(tokens[1]))),
 	}
 }
+
+// Structure is a variable which contains nested variables, either a map or a list.
+// Its value is the JSON representation of the nested values.
+type Structure interface {
+	Variable
+	// Children returns the nested variables in order, named by their map key or list index.
+	Children() []Variable
+	// IsList reports whether the nested variables are list items.
+	IsList() bool
+}
+
+type structure struct {
+	name     string
+	children []Variable
+	list     bool
+}
+
+// NewMap returns a structured variable whose nested variables are accessed by their names.
+func NewMap(name string, children ...Variable) Variable {
+	return structure{name: name, children: children}
+}
+
+// NewList returns a structured variable whose items are accessed by their index, starting at 0.
+func NewList(name string, items ...Variable) Variable {
+	children := make([]Variable, len(items))
+	for i, item := range items {
+		children[i] = Rename(item, strconv.Itoa(i))
+	}
+	return structure{name: name, children: children, list: true}
+}
+
+func (s structure) Name() string {
+	return s.name
+}
+
+func (s structure) Value() string {
+	sb := &strings.Builder{}
+	s.writeJSON(sb)
+	return sb.String()
+}
+
+func (s structure) Children() []Variable {
+	return s.children
+}
+
+func (s structure) IsList() bool {
+	return s.list
+}
+
+func (s structure) writeJSON(sb *strings.Builder) {
+	open, end := byte('{'), byte('}')
+	if s.list {
+		open, end = '[', ']'
+	}
+
+	sb.WriteByte(open)
+	for i, c := range s.children {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		if !s.list {
+			writeJSONString(sb, c.Name())
+			sb.WriteByte(':')
+		}
+
+		cs, ok := c.(structure)
+		if ok {
+			cs.writeJSON(sb)
+			continue
+		}
+		writeJSONString(sb, c.Value())
+	}
+	sb.WriteByte(end)
+}
+
+func writeJSONString(sb *strings.Builder, s string) {
+	// Marshalling a string never fails.
+	b, _ := json.Marshal(s)
+	sb.Write(b)
+}
+
+// Rename returns v with the given name, nested variables are kept.
+func Rename(v Variable, name string) Variable {
+	s, ok := v.(structure)
+	if ok {
+		s.name = name
+		return s
+	}
+	return variable{name: name, value: v.Value()}
+}
+
+// SplitPath splits the path of a nested variable into the name of its root variable and the keys of the nested variables,
+// e.g. "db.primary.host" into "db" and [primary host] or "hosts[2]" into "hosts" and [2].
+// If name is no valid path, path is empty.
+func SplitPath(name string) (root string, path []string) {
+	idx := strings.IndexAny(name, ".[")
+	if idx <= 0 {
+		return name, nil
+	}
+
+	root = name[:idx]
+	rest := name[idx:]
+	for rest != "" {
+		var key string
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key, rest = rest[:end], rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return name, nil
+			}
+			key, rest = rest[1:end], rest[end+1:]
+		default:
+			return name, nil
+		}
+		if key == "" {
+			return name, nil
+		}
+		path = append(path, key)
+	}
+	return
+}
+
+// Lookup returns the variable nested inside v at path.
+func Lookup(v Variable, path []string) (_ Variable, ok bool) {
+	for _, key := range path {
+		s, isStructure := v.(Structure)
+		if !isStructure {
+			return
+		}
+
+		found := false
+		for _, c := range s.Children() {
+			if c.Name() == key {
+				v, found = c, true
+				break
+			}
+		}
+		if !found {
+			return
+		}
+	}
+	return v, true
+}
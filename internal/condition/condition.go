@@ -20,9 +20,11 @@ type Buffer struct {
 }
 
 type frame struct {
-	id            int
-	fileName      string
-	lineNum       int
+	id       int
+	fileName string
+	lineNum  int
+	// branchLineNum is the line of the directive which opened the active branch.
+	branchLineNum int
 	parentActive  bool
 	branchMatched bool
 	active        bool
@@ -118,6 +120,7 @@ func (b *Buffer) PushIf(fileName string, lineNum int, eval bool) {
 		id:            b.nextID,
 		fileName:      fileName,
 		lineNum:       lineNum,
+		branchLineNum: lineNum,
 		parentActive:  parentActive,
 		branchMatched: eval,
 		active:        parentActive && eval,
@@ -125,7 +128,7 @@ func (b *Buffer) PushIf(fileName string, lineNum int, eval bool) {
 	b.frames = append(b.frames, f)
 }
 
-func (b *Buffer) IfElse(eval bool) error {
+func (b *Buffer) IfElse(lineNum int, eval bool) error {
 	b.stateMx.Lock()
 	defer b.stateMx.Unlock()
 
@@ -144,10 +147,13 @@ func (b *Buffer) IfElse(eval bool) error {
 
 	f.active = f.parentActive && eval
 	f.branchMatched = eval
+	if f.active {
+		f.branchLineNum = lineNum
+	}
 	return nil
 }
 
-func (b *Buffer) Else() error {
+func (b *Buffer) Else(lineNum int) error {
 	b.stateMx.Lock()
 	defer b.stateMx.Unlock()
 
@@ -163,9 +169,26 @@ func (b *Buffer) Else() error {
 	f.active = f.parentActive && !f.branchMatched
 	f.branchMatched = true
 	f.elseSeen = true
+	if f.active {
+		f.branchLineNum = lineNum
+	}
 	return nil
 }
 
+// Branch returns the line of the directive which opened the active branch
+// of the innermost condition inside fileName.
+func (b *Buffer) Branch(fileName string) (lineNum int, ok bool) {
+	b.stateMx.Lock()
+	defer b.stateMx.Unlock()
+
+	for i := len(b.frames) - 1; i >= 0; i-- {
+		if b.frames[i].fileName == fileName {
+			return b.frames[i].branchLineNum, true
+		}
+	}
+	return
+}
+
 func (b *Buffer) End() error {
 	b.stateMx.Lock()
 	defer b.stateMx.Unlock()
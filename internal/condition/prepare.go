@@ -16,10 +16,19 @@ var (
 	ErrIfElseAfterElse = errors.New("ifelse after else")
 )
 
-func (b *Buffer) IsTrue(fileName string, args []Arg, tr TokenResolver, vars ...common.Variable) (eval bool, err error) {
+// Evaluation is the result of a condition with its resolved operands.
+type Evaluation struct {
+	Result bool
+	Left   string
+	// Operator and Right are empty if the condition only checks whether Left is truthy.
+	Operator string
+	Right    string
+}
+
+func (b *Buffer) IsTrue(fileName string, args []Arg, tr TokenResolver, vars ...common.Variable) (ev Evaluation, err error) {
 	expr := bytes.TrimSpace(bytes.Join(argsToBytes(args), []byte{' '}))
 	if len(expr) == 0 {
-		return false, errors.New("empty condition")
+		return ev, errors.New("empty condition")
 	}
 
 	operators := [][]byte{
@@ -37,22 +46,25 @@ func (b *Buffer) IsTrue(fileName string, args []Arg, tr TokenResolver, vars ...c
 			continue
 		}
 
-		left, lErr := resolveOperand(fileName, before, tr, vars...)
-		if lErr != nil {
-			return false, lErr
+		ev.Operator = string(op)
+		ev.Left, err = resolveOperand(fileName, before, tr, vars...)
+		if err != nil {
+			return
 		}
-		right, rErr := resolveOperand(fileName, after, tr, vars...)
-		if rErr != nil {
-			return false, rErr
+		ev.Right, err = resolveOperand(fileName, after, tr, vars...)
+		if err != nil {
+			return
 		}
-		return compare(left, right, string(op))
+		ev.Result, err = compare(ev.Left, ev.Right, ev.Operator)
+		return
 	}
 
-	value, err := resolveOperand(fileName, expr, tr, vars...)
+	ev.Left, err = resolveOperand(fileName, expr, tr, vars...)
 	if err != nil {
-		return false, err
+		return
 	}
-	return isTruthy(value), nil
+	ev.Result = isTruthy(ev.Left)
+	return
 }
 
 func argsToBytes(args []Arg) [][]byte {
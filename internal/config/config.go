@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xiroxasx/yatt/internal/core"
+	"github.com/xiroxasx/yatt/internal/interpreter"
+	"gopkg.in/yaml.v3"
+)
+
+// FileNames contains the config file names which are looked up in the working directory, in order.
+var FileNames = []string{"yatt.yaml", ".yattrc"}
+
+var (
+	ErrUnknownTarget   = errors.New("unknown target")
+	ErrTargetsWithPath = errors.New("targets cannot be combined with explicit in or out paths")
+)
+
+// stdioPath is passed as in or out path to read from stdin or write to stdout.
+const stdioPath = "-"
+
+// Config contains the values of a project config file.
+// The keys are named after the corresponding CLI flags.
+// Unset values keep the CLI defaults.
+type Config struct {
+	Target        `yaml:",inline"`
+	Indent        *bool             `yaml:"indent"`
+	Strict        *bool             `yaml:"strict"`
+	Passthrough   *bool             `yaml:"passthrough"`
+	SourceMap     *bool             `yaml:"source-map"`
+	NoStats       *bool             `yaml:"no-stats"`
+	Stats         *bool             `yaml:"stats"`
+	Verbose       *bool             `yaml:"verbose"`
+	Trace         *bool             `yaml:"trace"`
+	Watch         *bool             `yaml:"watch"`
+	Check         *bool             `yaml:"check"`
+	Incremental   *bool             `yaml:"incremental"`
+	Jobs          *int              `yaml:"jobs"`
+	KeepGoing     *bool             `yaml:"keep-going"`
+	Transactional *bool             `yaml:"transactional"`
+	Prefixes      []string          `yaml:"prefix"`
+	Start         string            `yaml:"template-start"`
+	End           string            `yaml:"template-end"`
+	LineEnding    string            `yaml:"line-ending"`
+	Diagnostics   string            `yaml:"diagnostics"`
+	StatsFormat   string            `yaml:"stats-format"`
+	Targets       map[string]Target `yaml:"targets"`
+
+	// dir is the directory of the config file, relative paths of the config are resolved against it.
+	dir string
+}
+
+// Target describes a single set of in and out paths.
+// Var files, overrides, whitelists and blacklists of targets are appended to the top level ones.
+type Target struct {
+	In        string   `yaml:"in"`
+	Out       string   `yaml:"out"`
+	Vars      []string `yaml:"var"`
+	Set       []string `yaml:"set"`
+	SetFiles  []string `yaml:"set-file"`
+	Whitelist []string `yaml:"whitelist"`
+	Blacklist []string `yaml:"blacklist"`
+}
+
+// NamedOptions are the resolved options of a target.
+type NamedOptions struct {
+	Name    string
+	Options interpreter.Options
+}
+
+// Find returns the path of the first config file found in dir.
+// If none exists, an empty path is returned.
+func Find(dir string) (path string, err error) {
+	for _, name := range FileNames {
+		p := filepath.Join(dir, name)
+		_, err = os.Stat(p)
+		if err == nil {
+			return p, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+	}
+	return "", nil
+}
+
+// Load reads the config file at path.
+// Unknown keys are rejected to catch typos.
+func Load(path string) (c Config, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	err = dec.Decode(&c)
+	if errors.Is(err, io.EOF) {
+		// Empty config files are fine.
+		err = nil
+	}
+	if err != nil {
+		err = fmt.Errorf("parsing config %s: %v", path, err)
+	}
+	c.dir = filepath.Dir(path)
+	return
+}
+
+// Resolve returns the options of the given targets.
+// If no names are given, all targets are returned, sorted by name.
+// If the config does not declare any target, the top level paths are used.
+// Values of cli for which explicit returns true take precedence over the config.
+// Target names cannot be combined with explicit in or out paths.
+func (c Config) Resolve(cli interpreter.Options, explicit func(flag string) bool, names ...string) (ret []NamedOptions, err error) {
+	if len(names) > 0 && (explicit("in") || explicit("out")) {
+		return nil, ErrTargetsWithPath
+	}
+	if len(c.Targets) == 0 || explicit("in") || explicit("out") {
+		if len(names) > 0 && len(c.Targets) == 0 {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTarget, names[0])
+		}
+		return []NamedOptions{{Options: c.options(cli, explicit, Target{})}}, nil
+	}
+
+	if len(names) == 0 {
+		for name := range c.Targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	for _, name := range names {
+		t, ok := c.Targets[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTarget, name)
+		}
+		ret = append(ret, NamedOptions{
+			Name:    name,
+			Options: c.options(cli, explicit, t),
+		})
+	}
+	return
+}
+
+// options merges the config and the target into the cli options.
+func (c Config) options(cli interpreter.Options, explicit func(flag string) bool, t Target) (o interpreter.Options) {
+	o = cli
+	setString := func(flag string, dst *string, values ...string) {
+		if explicit(flag) {
+			return
+		}
+		for _, v := range values {
+			if v != "" {
+				*dst = v
+			}
+		}
+	}
+	setStrings := func(flag string, dst *[]string, values ...[]string) {
+		if explicit(flag) {
+			return
+		}
+		merged := make([]string, 0)
+		for _, v := range values {
+			merged = append(merged, v...)
+		}
+		if len(merged) > 0 {
+			*dst = merged
+		}
+	}
+	setBool := func(flag string, dst *bool, v *bool) {
+		if !explicit(flag) && v != nil {
+			*dst = *v
+		}
+	}
+
+	setString("in", &o.InPath, c.path(c.In), c.path(t.In))
+	setString("out", &o.OutPath, c.path(c.Out), c.path(t.Out))
+	setStrings("var", &o.VarFilePaths, c.varPaths(c.Vars), c.varPaths(t.Vars))
+	// Overrides of the command line are appended instead of replacing the config ones, so that they take precedence.
+	o.Set = append(append(append([]string(nil), c.Set...), t.Set...), cli.Set...)
+	o.SetFiles = append(append(append([]string(nil), c.setFilePaths(c.SetFiles)...), c.setFilePaths(t.SetFiles)...), cli.SetFiles...)
+	setStrings("whitelist", &o.FileWhitelist, c.Whitelist, t.Whitelist)
+	setStrings("blacklist", &o.FileBlacklist, c.Blacklist, t.Blacklist)
+	setStrings("prefix", &o.Prefixes, c.Prefixes)
+	setString("template-start", &o.TemplateStart, c.Start)
+	setString("template-end", &o.TemplateEnd, c.End)
+	setString("line-ending", &o.LineEnding, c.LineEnding)
+	setString("diagnostics", &o.Diagnostics, c.Diagnostics)
+	setString("stats-format", &o.StatsFormat, c.StatsFormat)
+	setBool("indent", &o.Indent, c.Indent)
+	setBool("strict", &o.Strict, c.Strict)
+	setBool("passthrough", &o.Passthrough, c.Passthrough)
+	setBool("source-map", &o.SourceMap, c.SourceMap)
+	setBool("no-stats", &o.NoStats, c.NoStats)
+	setBool("stats", &o.Stats, c.Stats)
+	setBool("verbose", &o.Verbose, c.Verbose)
+	setBool("trace", &o.Trace, c.Trace)
+	setBool("watch", &o.Watch, c.Watch)
+	setBool("check", &o.Check, c.Check)
+	setBool("incremental", &o.Incremental, c.Incremental)
+	setBool("keep-going", &o.KeepGoing, c.KeepGoing)
+	setBool("transactional", &o.Transactional, c.Transactional)
+	if !explicit("jobs") && c.Jobs != nil {
+		o.Jobs = *c.Jobs
+	}
+
+	// Copy slices to not share them between targets.
+	o.VarFilePaths = append([]string(nil), o.VarFilePaths...)
+	o.FileWhitelist = append([]string(nil), o.FileWhitelist...)
+	o.FileBlacklist = append([]string(nil), o.FileBlacklist...)
+	o.Prefixes = append([]string(nil), o.Prefixes...)
+	return
+}
+
+// path resolves the relative path p of the config against the directory of the config file.
+func (c Config) path(p string) string {
+	if p == "" || p == stdioPath || c.dir == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(c.dir, p)
+}
+
+// varPaths resolves the var file paths of the config, their optional format prefix is kept.
+func (c Config) varPaths(vars []string) (paths []string) {
+	for _, v := range vars {
+		p := core.VarFilePath(v)
+		paths = append(paths, v[:len(v)-len(p)]+c.path(p))
+	}
+	return
+}
+
+// setFilePaths resolves the paths of the "name=path" values of the config.
+func (c Config) setFilePaths(sets []string) (paths []string) {
+	for _, set := range sets {
+		name, p, ok := strings.Cut(set, "=")
+		if ok {
+			set = name + "=" + c.path(p)
+		}
+		paths = append(paths, set)
+	}
+	return
+}
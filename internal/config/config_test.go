@@ -0,0 +1,189 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	r "github.com/stretchr/testify/require"
+	"github.com/xiroxasx/yatt/internal/interpreter"
+)
+
+const testConfig = `indent: true
+jobs: 2
+var:
+  - common.var
+set:
+  - env=dev
+blacklist:
+  - \.png$
+targets:
+  web:
+    in: web/src
+    out: web/dest
+    var:
+      - web.var
+    set:
+      - region=eu
+  api:
+    in: api/src
+    out: api/dest
+`
+
+func writeConfig(t *testing.T, content string) (path string) {
+	t.Helper()
+
+	path = filepath.Join(t.TempDir(), "yatt.yaml")
+	r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return
+}
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path, err := Find(dir)
+	r.NoError(t, err)
+	r.Empty(t, path)
+
+	rc := filepath.Join(dir, ".yattrc")
+	r.NoError(t, os.WriteFile(rc, nil, 0o600))
+	path, err = Find(dir)
+	r.NoError(t, err)
+	r.Exactly(t, rc, path)
+
+	yml := filepath.Join(dir, "yatt.yaml")
+	r.NoError(t, os.WriteFile(yml, nil, 0o600))
+	path, err = Find(dir)
+	r.NoError(t, err)
+	r.Exactly(t, yml, path)
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	c, err := Load(writeConfig(t, ""))
+	r.NoError(t, err)
+	r.Empty(t, c.Targets)
+
+	_, err = Load(writeConfig(t, "indnet: true\n"))
+	r.Error(t, err)
+}
+
+func TestResolveTargets(t *testing.T) {
+	t.Parallel()
+
+	path := writeConfig(t, testConfig)
+	dir := filepath.Dir(path)
+	c, err := Load(path)
+	r.NoError(t, err)
+
+	cli := interpreter.Options{Jobs: 8, VarFilePaths: []string{}}
+	explicit := func(flag string) bool { return false }
+	targets, err := c.Resolve(cli, explicit)
+	r.NoError(t, err)
+	r.Len(t, targets, 2)
+
+	api := targets[0]
+	r.Exactly(t, "api", api.Name)
+	r.Exactly(t, filepath.Join(dir, "api/src"), api.Options.InPath)
+	r.Exactly(t, filepath.Join(dir, "api/dest"), api.Options.OutPath)
+	r.Exactly(t, []string{filepath.Join(dir, "common.var")}, api.Options.VarFilePaths)
+	r.Exactly(t, []string{`\.png$`}, api.Options.FileBlacklist)
+	r.True(t, api.Options.Indent)
+	r.Exactly(t, 2, api.Options.Jobs)
+
+	web := targets[1]
+	r.Exactly(t, "web", web.Name)
+	r.Exactly(t, []string{filepath.Join(dir, "common.var"), filepath.Join(dir, "web.var")}, web.Options.VarFilePaths)
+	r.Exactly(t, []string{"env=dev", "region=eu"}, web.Options.Set)
+
+	targets, err = c.Resolve(cli, explicit, "web")
+	r.NoError(t, err)
+	r.Len(t, targets, 1)
+	r.Exactly(t, filepath.Join(dir, "web/src"), targets[0].Options.InPath)
+
+	_, err = c.Resolve(cli, explicit, "unknown")
+	r.ErrorIs(t, err, ErrUnknownTarget)
+}
+
+func TestResolveExplicitFlags(t *testing.T) {
+	t.Parallel()
+
+	c, err := Load(writeConfig(t, testConfig))
+	r.NoError(t, err)
+
+	cli := interpreter.Options{
+		InPath:       "src",
+		OutPath:      "dest",
+		Jobs:         8,
+		VarFilePaths: []string{"cli.var"},
+		Set:          []string{"env=prod"},
+	}
+	explicit := func(flag string) bool {
+		switch flag {
+		case "in", "out", "var", "jobs":
+			return true
+		}
+		return false
+	}
+
+	// Explicit paths ignore the targets.
+	targets, err := c.Resolve(cli, explicit)
+	r.NoError(t, err)
+	r.Len(t, targets, 1)
+
+	opts := targets[0].Options
+	r.Exactly(t, "src", opts.InPath)
+	r.Exactly(t, "dest", opts.OutPath)
+	r.Exactly(t, []string{"cli.var"}, opts.VarFilePaths)
+	// Overrides of the command line are appended, so that they take precedence over the config.
+	r.Exactly(t, []string{"env=dev", "env=prod"}, opts.Set)
+	r.Exactly(t, 8, opts.Jobs)
+	r.True(t, opts.Indent)
+
+	// Targets cannot be combined with explicit paths.
+	_, err = c.Resolve(cli, explicit, "web")
+	r.ErrorIs(t, err, ErrTargetsWithPath)
+}
+
+// TestResolveRelativePaths must not run in parallel, since it changes the working directory.
+func TestResolveRelativePaths(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "project")
+	r.NoError(t, os.MkdirAll(filepath.Join(project, "src"), 0o700))
+	r.NoError(t, os.WriteFile(filepath.Join(project, "vars.yaml"), []byte("a: b\n"), 0o600))
+	r.NoError(t, os.WriteFile(filepath.Join(project, "name.txt"), []byte("yatt"), 0o600))
+	r.NoError(t, os.WriteFile(filepath.Join(project, "yatt.yaml"), []byte(`in: src
+out: dest
+var:
+  - yaml:vars.yaml
+set-file:
+  - name=name.txt
+`), 0o600))
+
+	other := filepath.Join(root, "other")
+	r.NoError(t, os.Mkdir(other, 0o700))
+	wd, err := os.Getwd()
+	r.NoError(t, err)
+	r.NoError(t, os.Chdir(other))
+	t.Cleanup(func() { r.NoError(t, os.Chdir(wd)) })
+
+	c, err := Load(filepath.Join("..", "project", "yatt.yaml"))
+	r.NoError(t, err)
+
+	cli := interpreter.Options{SetFiles: []string{"cli=cli.txt"}}
+	explicit := func(flag string) bool { return false }
+	targets, err := c.Resolve(cli, explicit)
+	r.NoError(t, err)
+	r.Len(t, targets, 1)
+
+	opts := targets[0].Options
+	r.DirExists(t, opts.InPath)
+	r.Exactly(t, filepath.Join("..", "project", "dest"), opts.OutPath)
+	r.Exactly(t, []string{"yaml:" + filepath.Join("..", "project", "vars.yaml")}, opts.VarFilePaths)
+	// Paths of the command line stay relative to the working directory.
+	r.Exactly(t, []string{"name=" + filepath.Join("..", "project", "name.txt"), "cli=cli.txt"}, opts.SetFiles)
+	r.FileExists(t, filepath.Join("..", "project", "name.txt"))
+	r.FileExists(t, filepath.Join("..", "project", "vars.yaml"))
+}
@@ -1,17 +1,19 @@
 package core
 
 import (
-	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/xiroxasx/yatt/internal/common"
 	"github.com/xiroxasx/yatt/internal/condition"
 	"github.com/xiroxasx/yatt/internal/foreach"
+	"github.com/xiroxasx/yatt/internal/sourcemap"
 )
 
 const (
@@ -27,8 +29,12 @@ var (
 	templateEndBytes   = common.TemplateEnd()
 
 	errEmptyVariableParameter  = errors.New("variable name or value must not be empty")
+	errEmptyPrefix             = errors.New("prefixes must not be empty")
+	errDelimitersOverlap       = errors.New("template start and end must not contain each other")
 	errDependencyCyclic        = errors.New("cyclic dependency detected")
-	errDependencyUnknownSyntax = fmt.Errorf("unknown syntax: %s <file path>", preprocessorImportName)
+	errUnresolvedVariable      = errors.New("unresolved variable")
+	errDependencyUnknownSyntax = fmt.Errorf("unknown syntax: %s <file path> [name=value ...]", preprocessorImportName)
+	errImportArgSyntax         = errors.New("import arguments must be of the form name=value")
 )
 
 // Core must implement necessary interfaces.
@@ -38,15 +44,27 @@ var (
 )
 
 type Core struct {
-	l        zerolog.Logger
-	prefixes [][]byte
-	opts     Options
+	l             zerolog.Logger
+	prefixes      [][]byte
+	lineEnding    []byte
+	templateStart []byte
+	templateEnd   []byte
+	opts          Options
 
 	ignoreIndex  ignoreIndexes
 	depsResolver dependencyResolver
 	feb          foreach.Buffer
 	cb           condition.Buffer
 
+	// sourceMap records the origin of every interpreted line, if requested by the interpreted file.
+	sourceMap *sourcemap.Map
+	// imports contains the import statements which led to the currently interpreted file, innermost first.
+	imports []sourcemap.Frame
+	// importScopes contains the scopes of the imports which led to the currently interpreted file, innermost last.
+	importScopes []importScope
+	// stats of the currently interpreted file.
+	stats Stats
+
 	registries
 
 	*sync.Mutex
@@ -54,6 +72,41 @@ type Core struct {
 
 type Options struct {
 	PreserveIndent bool
+	// LineEnding is used to split var files and join the interpreted lines.
+	// Defaults to the line ending of the current OS.
+	LineEnding []byte
+	// TemplateStart and TemplateEnd delimit variables and functions.
+	// Default to "{{" and "}}".
+	TemplateStart []byte
+	TemplateEnd   []byte
+	// Strict makes unresolved variables an error instead of an empty value.
+	Strict bool
+	// Passthrough emits tokens which are neither a known variable nor a known function verbatim,
+	// so that foreign template syntax like "{{ .Values.x }}" is kept. It takes precedence over Strict.
+	Passthrough bool
+	// Trace writes every evaluation step to the logger at trace level.
+	Trace bool
+}
+
+// Validate checks the prefixes and options for values which cannot be interpreted.
+func Validate(prefixes []string, opts Options) error {
+	for _, p := range prefixes {
+		if strings.TrimSpace(p) == "" {
+			return errEmptyPrefix
+		}
+	}
+
+	start, end := opts.TemplateStart, opts.TemplateEnd
+	if len(start) == 0 {
+		start = templateStartBytes
+	}
+	if len(end) == 0 {
+		end = templateEndBytes
+	}
+	if bytes.Contains(start, end) || bytes.Contains(end, start) {
+		return errDelimitersOverlap
+	}
+	return nil
 }
 
 type ignoreIndexes map[string]ignoreState
@@ -63,10 +116,14 @@ type registries struct {
 	varRegistryForeach   variableRegistry
 	varRegistryLocal     variableRegistry
 	varRegistryGlobal    variableRegistry
+	// varRegistryOverride contains the global variables set on the command line, they take precedence over var files.
+	varRegistryOverride variableRegistry
 }
 
 type variableRegistry struct {
 	entries map[string]vars
+	// order contains the registers in the order they have been created.
+	order []string
 	*sync.Mutex
 }
 
@@ -82,6 +139,20 @@ type InterpreterFile struct {
 	Name string
 	Buf  io.Writer
 	RC   io.ReadCloser
+	// SourceMap optionally records the origin of every line written to Buf.
+	SourceMap *sourcemap.Map
+}
+
+// DefaultPrefixes returns the directive prefixes which are used if none are configured.
+func DefaultPrefixes() []string {
+	const prefixName = "yatt"
+
+	return []string{
+		fmt.Sprintf("#%s", prefixName),
+		fmt.Sprintf("# %s", prefixName),
+		fmt.Sprintf("//%s", prefixName),
+		fmt.Sprintf("// %s", prefixName),
+	}
 }
 
 func New(l zerolog.Logger, prefixes []string, opts Options) *Core {
@@ -89,7 +160,15 @@ func New(l zerolog.Logger, prefixes []string, opts Options) *Core {
 	for i := range prefixes {
 		ps[i] = []byte(prefixes[i])
 	}
+	// Match the longest prefix first, so that prefixes may share the same beginning.
+	sort.SliceStable(ps, func(i, j int) bool {
+		return len(ps[i]) > len(ps[j])
+	})
 
+	return newCore(l.With().Str("mod", "core").Logger(), ps, opts)
+}
+
+func newCore(l zerolog.Logger, prefixes [][]byte, opts Options) *Core {
 	newVarReg := func() variableRegistry {
 		return variableRegistry{
 			entries: make(map[string]vars, 0),
@@ -97,24 +176,81 @@ func New(l zerolog.Logger, prefixes []string, opts Options) *Core {
 		}
 	}
 
+	le := opts.LineEnding
+	if len(le) == 0 {
+		le = lineEnding
+	}
+
+	start := opts.TemplateStart
+	if len(start) == 0 {
+		start = templateStartBytes
+	}
+	end := opts.TemplateEnd
+	if len(end) == 0 {
+		end = templateEndBytes
+	}
+
 	return &Core{
-		l:            l.With().Str("mod", "core").Logger(),
-		opts:         opts,
-		prefixes:     ps,
-		ignoreIndex:  make(ignoreIndexes, 0),
-		feb:          foreach.NewForeachBuffer(lineEnding),
-		cb:           condition.NewConditionBuffer(),
-		Mutex:        &sync.Mutex{},
-		depsResolver: newDependencyResolver(),
+		l:             l,
+		opts:          opts,
+		prefixes:      prefixes,
+		lineEnding:    le,
+		templateStart: start,
+		templateEnd:   end,
+		ignoreIndex:   make(ignoreIndexes, 0),
+		feb:           foreach.NewForeachBuffer(le),
+		cb:            condition.NewConditionBuffer(),
+		Mutex:         &sync.Mutex{},
+		depsResolver:  newDependencyResolver(),
 		registries: registries{
 			varRegistryCondition: newVarReg(),
 			varRegistryForeach:   newVarReg(),
 			varRegistryLocal:     newVarReg(),
 			varRegistryGlobal:    newVarReg(),
+			varRegistryOverride:  newVarReg(),
 		},
 	}
 }
 
+// LineEnding returns the line ending used to join the interpreted lines.
+func (c *Core) LineEnding() []byte {
+	return c.lineEnding
+}
+
+// SetLineEnding changes the line ending used to join the interpreted lines.
+// It must be called before interpreting any file.
+func (c *Core) SetLineEnding(le []byte) {
+	c.lineEnding = le
+	c.opts.LineEnding = le
+	c.feb = foreach.NewForeachBuffer(le)
+}
+
+// Fork creates a new core with the same prefixes and options.
+// Global and override variables are copied, every other state starts off empty.
+// Forks can be used concurrently to the original core.
+func (c *Core) Fork() (f *Core) {
+	prefixes := make([][]byte, len(c.prefixes))
+	for i, p := range c.prefixes {
+		prefixes[i] = bytes.Clone(p)
+	}
+	f = newCore(c.l, prefixes, c.opts)
+
+	c.varRegistryGlobal.Lock()
+	defer c.varRegistryGlobal.Unlock()
+	for reg, vs := range c.varRegistryGlobal.entries {
+		f.varRegistryGlobal.entries[reg] = append(vars(nil), vs...)
+	}
+	f.varRegistryGlobal.order = append([]string(nil), c.varRegistryGlobal.order...)
+
+	c.varRegistryOverride.Lock()
+	defer c.varRegistryOverride.Unlock()
+	for reg, vs := range c.varRegistryOverride.entries {
+		f.varRegistryOverride.entries[reg] = append(vars(nil), vs...)
+	}
+	f.varRegistryOverride.order = append([]string(nil), c.varRegistryOverride.order...)
+	return
+}
+
 func (c *Core) VarsLookupGlobalFile(name string) []common.Variable {
 	return c.varsLookupGlobalFile(name)
 }
@@ -124,6 +260,11 @@ func (c *Core) VarsLookupGlobal() []common.Variable {
 }
 
 func (c *Core) Interpret(file InterpreterFile) (err error) {
+	c.sourceMap = file.SourceMap
+	c.stats = Stats{}
+	defer func() {
+		c.sourceMap = nil
+	}()
 	return c.interpret(file, nil)
 }
 
@@ -160,32 +301,37 @@ func (c *Core) interpret(file InterpreterFile, additionalIndent []byte) (err err
 	var (
 		// Currently read line.
 		lineNum int
-		// Limits reads to 65536 bytes per line.
-		scanner = bufio.NewScanner(file.RC)
+		lr      = newLineReader(file.RC)
 	)
-	for scanner.Scan() {
-		err = scanner.Err()
-		if err != nil {
-			return
-		}
-
+	for lr.Scan() {
 		lineNum++
-		line := scanner.Bytes()
+		line := lr.Bytes()
 		currentLineIndent := make([]byte, 0)
+		var lineIndet []byte
 		if c.opts.PreserveIndent {
 			// Line indents are required, check current line indents.
-			lineIndet := common.GetLeadingWhitespace(line)
+			lineIndet = common.GetLeadingWhitespace(line)
 			currentLineIndent = append(lineIndet, additionalIndent...)
 			line = line[len(lineIndet):]
 		}
 
 		err = c.searchTokensAndExecute(file.Name, line, currentLineIndent, file.Buf, lineNum)
 		if err != nil {
-			return
+			return locate(err, file.Name, lineNum, len(lineIndet))
 		}
 	}
+	err = lr.Err()
+	if err != nil {
+		return
+	}
 
-	return c.ensureNoOpenConditions(file.Name)
+	err = c.ensureNoOpenConditions(file.Name)
+	if err != nil {
+		d := locate(err, file.Name, lineNum, 0)
+		d.Directive = directiveNameConditionIf
+		return d
+	}
+	return
 }
 
 //
@@ -197,13 +343,12 @@ func remapArgsWithVariables(fncNameStr string, varsFromArgs, additionalVars []co
 
 additionalVar:
 	for idx := range varsFromArgs {
-		for _, av := range additionalVars {
-			// Overwrite variable value if the names match.
-			// This may be the case for "foreach"-variables.
-			if varsFromArgs[idx].Name() == av.Name() {
-				values[idx] = []byte(av.Value())
-				continue additionalVar
-			}
+		// Overwrite variable value if the names match.
+		// This may be the case for "foreach"-variables.
+		av, ok := lookupAdditionalVar(additionalVars, varsFromArgs[idx].Name())
+		if ok {
+			values[idx] = []byte(av.Value())
+			continue additionalVar
 		}
 
 		// Keep variable name intact so the function call can retrieve the var's value.
@@ -217,6 +362,30 @@ additionalVar:
 	return
 }
 
+// lookupAdditionalVar returns the additional variable name, which may be the path of a nested value, e.g. "value.host".
+func lookupAdditionalVar(additionalVars []common.Variable, name string) (v common.Variable, ok bool) {
+	for _, av := range additionalVars {
+		if av.Name() == name {
+			return av, true
+		}
+	}
+
+	root, path := common.SplitPath(name)
+	if len(path) == 0 {
+		return
+	}
+	for _, av := range additionalVars {
+		if av.Name() != root {
+			continue
+		}
+		v, ok = common.Lookup(av, path)
+		if ok {
+			return common.Rename(v, name), true
+		}
+	}
+	return
+}
+
 func (c *Core) cutPrefix(b []byte) (ret []byte) {
 	prefix := c.matchedPrefixToken(b)
 	if prefix == nil {
@@ -226,7 +395,10 @@ func (c *Core) cutPrefix(b []byte) (ret []byte) {
 }
 
 func trimLine(b, prefix []byte) []byte {
-	return bytes.TrimPrefix(bytes.TrimSpace(b), append(prefix, ' '))
+	// Build the needle in a fresh slice, the prefix may be shared with other cores.
+	needle := make([]byte, 0, len(prefix)+1)
+	needle = append(append(needle, prefix...), ' ')
+	return bytes.TrimPrefix(bytes.TrimSpace(b), needle)
 }
 
 func (c *Core) matchedPrefixToken(line []byte) (prefix []byte) {
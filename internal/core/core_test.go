@@ -17,6 +17,8 @@ import (
 	"github.com/rs/zerolog/log"
 	r "github.com/stretchr/testify/require"
 	"github.com/xiroxasx/yatt/internal/common"
+	"github.com/xiroxasx/yatt/internal/diagnostic"
+	"github.com/xiroxasx/yatt/internal/sourcemap"
 )
 
 const floatThreshold = 1e-9
@@ -31,19 +33,22 @@ func TestDependenciesAreCyclic(t *testing.T) {
 
 	type testCaseWrapper struct {
 		fail  bool
+		cycle []string
 		cases []importCase
 	}
 
 	testCases := []testCaseWrapper{
 		{
-			fail: true,
+			fail:  true,
+			cycle: []string{"fileA", "fileB", "fileA"},
 			cases: []importCase{
 				{src: "fileA", imp: "fileB"},
 				{src: "fileB", imp: "fileA"},
 			},
 		},
 		{
-			fail: true,
+			fail:  true,
+			cycle: []string{"fileA", "fileB", "fileC", "fileA"},
 			cases: []importCase{
 				{src: "fileA", imp: "fileB"},
 				{src: "fileB", imp: "fileC"},
@@ -51,7 +56,8 @@ func TestDependenciesAreCyclic(t *testing.T) {
 			},
 		},
 		{
-			fail: true,
+			fail:  true,
+			cycle: []string{"fileA", "fileB", "fileC", "fileE", "fileA"},
 			cases: []importCase{
 				{src: "fileA", imp: "fileB"},
 				{src: "fileB", imp: "fileC"},
@@ -61,7 +67,8 @@ func TestDependenciesAreCyclic(t *testing.T) {
 			},
 		},
 		{
-			fail: true,
+			fail:  true,
+			cycle: []string{"fileB", "fileC", "fileD", "fileB"},
 			cases: []importCase{
 				{src: "fileA", imp: "fileB"},
 				{src: "fileB", imp: "fileC"},
@@ -73,7 +80,8 @@ func TestDependenciesAreCyclic(t *testing.T) {
 			},
 		},
 		{
-			fail: true,
+			fail:  true,
+			cycle: []string{"fileC", "fileD", "fileC"},
 			cases: []importCase{
 				{src: "fileA", imp: "fileB"},
 				{src: "fileB", imp: "fileC"},
@@ -85,7 +93,8 @@ func TestDependenciesAreCyclic(t *testing.T) {
 			},
 		},
 		{
-			fail: true,
+			fail:  true,
+			cycle: []string{"fileC", "fileD", "fileE", "fileC"},
 			cases: []importCase{
 				{src: "fileA", imp: "fileB"},
 				{src: "fileB", imp: "fileC"},
@@ -110,6 +119,17 @@ func TestDependenciesAreCyclic(t *testing.T) {
 				{src: "fileY", imp: "fileZ"},
 			},
 		},
+		{
+			// Diamonds are no cycles.
+			cases: []importCase{
+				{src: "fileA", imp: "fileB"},
+				{src: "fileA", imp: "fileC"},
+				{src: "fileB", imp: "fileD"},
+				{src: "fileC", imp: "fileD"},
+				{src: "fileD", imp: "fileE"},
+				{src: "fileB", imp: "fileE"},
+			},
+		},
 	}
 
 	for i, tcw := range testCases {
@@ -120,9 +140,9 @@ func TestDependenciesAreCyclic(t *testing.T) {
 			dr.addDependency(tc.src, tc.imp)
 		}
 
-		startCase := tcw.cases[0]
-		ok := dr.CheckForCyclicDependencies(startCase.src, startCase.imp)
-		r.Equal(t, tcw.fail, ok, "case=%d", i)
+		cycle := dr.findCycle(tcw.cases[0].src)
+		r.Equal(t, tcw.fail, len(cycle) > 0, "case=%d", i)
+		r.Equal(t, tcw.cycle, cycle, "case=%d", i)
 	}
 }
 
@@ -133,6 +153,140 @@ func TestImportPaths(t *testing.T) {
 	c := New(l, []string{"# yatt"}, Options{})
 	err := c.ImportPathCheckCyclicDependencies("testdata/deps/fileA.txt")
 	r.ErrorIs(t, err, errDependencyCyclic)
+	r.ErrorContains(t, err, "testdata/deps/fileC.txt -> testdata/deps/fileD.txt -> testdata/deps/fileC.txt")
+}
+
+func TestImportCycles(t *testing.T) {
+	t.Parallel()
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	prefixes := []string{"# yatt", "// yatt"}
+
+	type testCase struct {
+		files map[string]string
+		cycle string
+	}
+
+	testCases := []testCase{
+		{
+			// Diamond: both partials import the same file.
+			files: map[string]string{
+				"a": "# yatt import {b}\n# yatt import {c}\n",
+				"b": "// yatt import {d}\n",
+				"c": "# yatt import {d}\n",
+				"d": "d\n",
+			},
+		},
+		{
+			// Imports inside blocks and with other prefixes are followed.
+			files: map[string]string{
+				"a": "# yatt import {b}\n",
+				"b": "# yatt if {{x}}\n  // yatt import {c}\n# yatt ifend\n",
+				"c": "# yatt foreach 2\n# yatt import {a}\n# yatt foreachend\n",
+			},
+			cycle: "{a} -> {b} -> {c} -> {a}",
+		},
+		{
+			files: map[string]string{
+				"a": "# yatt import {b}\n# yatt import {c}\n",
+				"b": "b\n",
+				"c": "// yatt import {c}\n",
+			},
+			cycle: "{c} -> {c}",
+		},
+		{
+			// Import arguments follow the path.
+			files: map[string]string{
+				"a": "# yatt import {b} name=b title=\"a b\"\n",
+				"b": "# yatt import {a} port={{add(1, 2)}}\n",
+			},
+			cycle: "{a} -> {b} -> {a}",
+		},
+	}
+
+	for i, tc := range testCases {
+		dir := t.TempDir()
+		replacer := make([]string, 0)
+		for name := range tc.files {
+			replacer = append(replacer, "{"+name+"}", filepath.Join(dir, name+".txt"))
+		}
+		rep := strings.NewReplacer(replacer...)
+		for name, content := range tc.files {
+			r.NoError(t, os.WriteFile(filepath.Join(dir, name+".txt"), []byte(rep.Replace(content)), 0o600), "case=%d", i)
+		}
+
+		c := New(l, prefixes, Options{})
+		err := c.ImportPathCheckCyclicDependencies(filepath.Join(dir, "a.txt"))
+		if tc.cycle == "" {
+			r.NoError(t, err, "case=%d", i)
+			continue
+		}
+		r.ErrorIs(t, err, errDependencyCyclic, "case=%d", i)
+		r.ErrorContains(t, err, rep.Replace(tc.cycle), "case=%d", i)
+	}
+}
+
+func TestImportArgs(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "vhost.conf")
+	child := filepath.Join(dir, "child.conf")
+	files := map[string]string{
+		partial: "# yatt var upstream = backend\n" +
+			"server {{name}}:{{port}} {{title}} {{upstream}}\n" +
+			"# yatt import " + child + " suffix=-x\n" +
+			"{{var(leak, partial)}}\n",
+		child: "child {{name}}{{suffix}} {{upstream}}\n",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	input := `# yatt var svc = api
+# yatt var web = web
+# yatt import ` + partial + ` name={{svc}} port=8080 title="Hello World"
+# yatt foreach [ {{web}} ]
+# yatt import ` + partial + ` name={{value}} port={{add(index, 80)}}
+loop {{leak}}
+# yatt foreachend
+{{name}}{{suffix}}{{upstream}}{{leak}}`
+	buf := &bytes.Buffer{}
+	c := New(zerolog.Nop(), []string{"# yatt"}, Options{})
+	err := c.Interpret(InterpreterFile{
+		Name: "main.txt",
+		Buf:  buf,
+		RC:   io.NopCloser(strings.NewReader(input)),
+	})
+	r.NoError(t, err)
+	// Arguments are visible inside the import and its children, locals of the partial neither leak into the importer nor its children.
+	expected := "server api:8080 Hello World backend\nchild api-x \n\n" +
+		"server web:80  backend\nchild web-x \n\nloop \n\n"
+	r.Exactly(t, expected, buf.String())
+
+	ds, err := c.Lint("main.txt", strings.NewReader(input))
+	r.NoError(t, err)
+	messages := make([]string, len(ds))
+	for j, d := range ds {
+		messages[j] = fmt.Sprintf("%s:%d %s", filepath.Base(d.File), d.Line, d.Message)
+	}
+	r.Exactly(t, []string{
+		`child.conf:1 variable "upstream" is never declared`,
+		`main.txt:6 variable "leak" is never declared`,
+		`main.txt:8 variable "name" is never declared`,
+		`main.txt:8 variable "suffix" is never declared`,
+		`main.txt:8 variable "upstream" is never declared`,
+		`main.txt:8 variable "leak" is never declared`,
+	}, messages)
+
+	for _, stmt := range []string{"name", "=api", `title="open`, "port={{add(1, 2)"} {
+		err = c.Interpret(InterpreterFile{
+			Name: "invalid.txt",
+			Buf:  &bytes.Buffer{},
+			RC:   io.NopCloser(strings.NewReader("# yatt import " + child + " " + stmt)),
+		})
+		r.ErrorIs(t, err, errImportArgSyntax, stmt)
+	}
 }
 
 func TestSetLocalVarByArg(t *testing.T) {
@@ -647,7 +801,7 @@ func TestInitLocalVariablesByFiles(t *testing.T) {
 	vars := c.VarsLookupGlobal()
 	r.Exactly(t, 0, len(vars))
 
-	c.InitGlobalVariablesByFiles(filepath.Join("testdata", "vars", "in", "yatt.var"))
+	r.NoError(t, c.InitGlobalVariablesByFiles(filepath.Join("testdata", "vars", "in", "yatt.var")))
 	err = c.Interpret(InterpreterFile{
 		Name: inPath,
 		Buf:  buf,
@@ -660,6 +814,195 @@ func TestInitLocalVariablesByFiles(t *testing.T) {
 	r.Exactly(t, 1, len(vars))
 }
 
+func TestInitGlobalVariablesByFormats(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		fileName string
+		arg      string
+		content  string
+	}
+
+	dir := t.TempDir()
+	expected := []string{"db.host=localhost", "db.port=5432", "hosts[0]=a", "hosts[1]=b", "name=yatt", "debug=true"}
+	tcs := []testCase{
+		{
+			fileName: "vars.yaml",
+			content:  "db:\n  host: localhost\n  port: 5432\nhosts: [a, b]\nname: yatt\ndebug: true\n",
+		},
+		{
+			fileName: "vars.json",
+			content:  `{"db": {"host": "localhost", "port": 5432}, "hosts": ["a", "b"], "name": "yatt", "debug": true}`,
+		},
+		{
+			fileName: "vars.toml",
+			content:  "hosts = [\"a\", \"b\"]\nname = \"yatt\"\ndebug = true\n\n[db]\nhost = \"localhost\"\nport = 5432\n",
+		},
+		{
+			fileName: "vars.env",
+			content:  "# comment\nexport db.host=localhost\ndb.port=5432 # port\nhosts[0]='a'\nhosts[1]=\"b\"\n\nname=yatt\ndebug=true\n",
+		},
+		{
+			// The format prefix takes precedence over the extension.
+			fileName: "vars.txt",
+			arg:      "yaml:",
+			content:  "db: {host: localhost, port: 5432}\nhosts:\n  - a\n  - b\nname: yatt\ndebug: true\n",
+		},
+	}
+
+	for i, tc := range tcs {
+		path := filepath.Join(dir, tc.fileName)
+		r.NoError(t, os.WriteFile(path, []byte(tc.content), 0o600))
+
+		c := New(zerolog.Nop(), []string{"# yatt"}, Options{})
+		r.NoError(t, c.InitGlobalVariablesByFiles(tc.arg+path), "case=%d", i)
+
+		// Variables are registered under the var file path, in the order of the file.
+		actual := flattenVars(c.VarsLookupGlobalFile(path))
+		if tc.fileName == "vars.toml" {
+			// Tables follow the top level keys in TOML.
+			r.ElementsMatch(t, expected, actual, "case=%d", i)
+		} else {
+			r.Exactly(t, expected, actual, "case=%d", i)
+		}
+		buf := &bytes.Buffer{}
+		err := c.Interpret(InterpreterFile{
+			Name: "vars.txt",
+			Buf:  buf,
+			RC:   io.NopCloser(strings.NewReader("{{db.host}}:{{db.port}} {{hosts[1]}} {{name}} {{debug}}")),
+		})
+		r.NoError(t, err, "case=%d", i)
+		r.Exactly(t, "localhost:5432 b yatt true\n", buf.String(), "case=%d", i)
+
+		ds, err := c.LintVarFile(tc.arg + path)
+		r.NoError(t, err, "case=%d", i)
+		r.Empty(t, ds, "case=%d", i)
+	}
+
+	// Lines of env files are not limited in size.
+	long := strings.Repeat("x", 5*lineReaderBufSize/2)
+	env := filepath.Join(dir, "long.env")
+	r.NoError(t, os.WriteFile(env, []byte("long="+long+"\nname=yatt\n"), 0o600))
+	c := New(zerolog.Nop(), []string{"# yatt"}, Options{})
+	r.NoError(t, c.InitGlobalVariablesByFiles(env))
+	buf := &bytes.Buffer{}
+	err := c.Interpret(InterpreterFile{
+		Name: "long.txt",
+		Buf:  buf,
+		RC:   io.NopCloser(strings.NewReader("{{long}} {{name}}")),
+	})
+	r.NoError(t, err)
+	r.True(t, long+" yatt\n" == buf.String(), "rendered content does not match (len=%d)", buf.Len())
+
+	invalid := filepath.Join(dir, "invalid.json")
+	r.NoError(t, os.WriteFile(invalid, []byte(`["no", "map"]`), 0o600))
+	c = New(zerolog.Nop(), []string{"# yatt"}, Options{})
+	r.ErrorContains(t, c.InitGlobalVariablesByFiles(invalid), "must contain a map")
+	ds, err := c.LintVarFile(invalid)
+	r.NoError(t, err)
+	r.Len(t, ds, 1)
+}
+
+func TestStructuredVariables(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "vars.yaml")
+	vars := "db:\n  primary:\n    host: db1\n    port: 5432\nhosts: [a, b, c]\nservers:\n  - name: api\n    port: 8080\n  - name: web\n    port: 80\n"
+	r.NoError(t, os.WriteFile(path, []byte(vars), 0o600))
+
+	c := New(zerolog.Nop(), []string{"# yatt"}, Options{})
+	r.NoError(t, c.InitGlobalVariablesByFiles(path))
+
+	input := `{{db.primary.host}} {{hosts[2]}} {{upper(db.primary.host)}} {{db}}
+# yatt foreach {{hosts}}
+{{index}} {{key}} {{value}}
+# yatt foreachend
+# yatt foreach [ {{db.primary}} ]
+{{key}}={{value}}
+# yatt foreachend
+# yatt foreach {{servers}}
+{{value.name}}:{{value.port}}
+# yatt if {{value.port}} > 100
+big
+# yatt ifend
+# yatt foreachend`
+	buf := &bytes.Buffer{}
+	err := c.Interpret(InterpreterFile{
+		Name: "structured.txt",
+		Buf:  buf,
+		RC:   io.NopCloser(strings.NewReader(input)),
+	})
+	r.NoError(t, err)
+	expected := `db1 c DB1 {"primary":{"host":"db1","port":"5432"}}
+0 0 a
+1 1 b
+2 2 c
+host=db1
+port=5432
+api:8080
+big
+web:80
+`
+	r.Exactly(t, expected, buf.String())
+}
+
+func TestOverridePrecedence(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.yaml")
+	second := filepath.Join(dir, "second.yaml")
+	r.NoError(t, os.WriteFile(first, []byte("name: first\nfile: first\nregion: eu\n"), 0o600))
+	r.NoError(t, os.WriteFile(second, []byte("name: second\nfile: second\n"), 0o600))
+
+	c := New(zerolog.Nop(), []string{"# yatt"}, Options{})
+	r.NoError(t, c.InitGlobalVariablesByFiles(first, second))
+	r.NoError(t, c.SetOverrideVariable("name", "cli"))
+	r.NoError(t, c.SetOverrideVariable("extra", "x"))
+	r.ErrorIs(t, c.SetOverrideVariable("", "x"), errEmptyVariableParameter)
+
+	input := `{{file}} {{region}} {{name}} {{extra}}
+# yatt foreach [ {{YATT_GLOBAL}} ]
+{{value}}
+# yatt foreachend
+# yatt foreach [ {{region}} ]
+{{var(name, loop)}}
+{{name}}
+# yatt foreachend
+# yatt if {{region}} == eu
+{{var(name, condition)}}
+{{name}}
+# yatt ifend
+# yatt var name = local
+{{name}}`
+	buf := &bytes.Buffer{}
+	err := c.Interpret(InterpreterFile{
+		Name: "precedence.txt",
+		Buf:  buf,
+		RC:   io.NopCloser(strings.NewReader(input)),
+	})
+	r.NoError(t, err)
+	// The last var file wins over the previous ones, overrides win over all var files.
+	// Foreach and condition scopes shadow the overrides, local variables are used once they are declared.
+	expected := "second eu cli x\ncli\nfirst\neu\ncli\nsecond\nx\n\nloop\n\ncondition\nlocal\n"
+	r.Exactly(t, expected, buf.String())
+}
+
+func TestSequentialForeach(t *testing.T) {
+	t.Parallel()
+
+	input := `# yatt var a = 1
+# yatt var b = 2
+# yatt foreach [ {{a}}, {{b}} ]
+first={{value}}
+# yatt foreachend
+# yatt foreach [ {{b}} ]
+second={{value}}
+# yatt foreachend`
+	buf := interpretString(t, input)
+	r.Exactly(t, "first=1\nfirst=2\nsecond=2\n", buf.String())
+}
+
 func TestForeach(t *testing.T) {
 	t.Parallel()
 
@@ -683,6 +1026,454 @@ func TestForeach(t *testing.T) {
 	r.Exactly(t, "0 * (0 * 1) = 0\n1 * (1 * 2) = 2\n2 * (2 * 3) = 12\n", buf.String())
 }
 
+func TestLongLines(t *testing.T) {
+	t.Parallel()
+
+	const lineLen = 3 * 1024 * 1024
+
+	dir := t.TempDir()
+	long := func(c string) string {
+		return strings.Repeat(c, lineLen)
+	}
+	nested := filepath.Join(dir, "nested.txt")
+	partial := filepath.Join(dir, "partial.txt")
+	template := filepath.Join(dir, "template.txt")
+	files := map[string]string{
+		nested:  long("n") + "\n",
+		partial: long("p") + "{{upper(partial)}}\n# yatt import " + nested + "\n",
+		template: "# yatt var name = yatt\n" +
+			long("t") + "{{upper(name)}}\n" +
+			"# yatt import " + partial + "\n" +
+			"# yatt foreach 2\n" +
+			long("f") + "{{index}}\n" +
+			"# yatt foreachend\n",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	c := New(l, []string{"# yatt"}, Options{LineEnding: []byte("\n")})
+	r.NoError(t, c.ImportPathCheckCyclicDependencies(template))
+	r.ElementsMatch(t, []string{partial, nested}, c.Dependencies(template))
+
+	rc, err := os.Open(template)
+	r.NoError(t, err)
+	buf := &bytes.Buffer{}
+	err = c.Interpret(InterpreterFile{
+		Name: template,
+		Buf:  buf,
+		RC:   rc,
+	})
+	r.NoError(t, err)
+
+	expected := long("t") + "YATT\n" +
+		long("p") + "PARTIAL\n" +
+		long("n") + "\n" +
+		long("f") + "0\n" +
+		long("f") + "1\n"
+	r.True(t, expected == buf.String(), "rendered content does not match (len=%d, expected=%d)", buf.Len(), len(expected))
+}
+
+func TestLineReader(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("x", 5*lineReaderBufSize/2)
+	input := "short\r\n" + long + "\n\n" + long + "\r\nlast"
+	lr := newLineReader(strings.NewReader(input))
+
+	lines := make([]string, 0)
+	for lr.Scan() {
+		lines = append(lines, string(lr.Bytes()))
+	}
+	r.NoError(t, lr.Err())
+	r.Equal(t, []string{"short", long, "", long, "last"}, lines)
+}
+
+func TestDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.txt")
+	partial := filepath.Join(dir, "partial.txt")
+	files := map[string]string{
+		bad:     "ok\n  # yatt unknown arg\n",
+		partial: "partial\n# yatt import " + bad + "\n",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	type testCase struct {
+		content  string
+		expected diagnostic.Diagnostic
+	}
+
+	testCases := []testCase{
+		{
+			content: "line\n  {{upper(a)}} {{nosuch(a)}}\n",
+			expected: diagnostic.Diagnostic{
+				File:     "root.txt",
+				Line:     2,
+				Column:   16,
+				Function: "nosuch",
+			},
+		},
+		{
+			content: "line\n# yatt import " + partial + "\n",
+			expected: diagnostic.Diagnostic{
+				File:      bad,
+				Line:      2,
+				Column:    3,
+				Directive: "unknown",
+				ImportStack: []diagnostic.Frame{
+					{File: partial, Line: 2},
+					{File: "root.txt", Line: 2},
+				},
+			},
+		},
+		{
+			content: "# yatt if 1 == 1\nline\n",
+			expected: diagnostic.Diagnostic{
+				File:      "root.txt",
+				Line:      2,
+				Directive: "if",
+			},
+		},
+	}
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	for i, tc := range testCases {
+		c := New(l, []string{"# yatt"}, Options{})
+		err := c.Interpret(InterpreterFile{
+			Name: "root.txt",
+			Buf:  &bytes.Buffer{},
+			RC:   io.NopCloser(strings.NewReader(tc.content)),
+		})
+
+		var d *diagnostic.Diagnostic
+		r.ErrorAs(t, err, &d, "case=%d", i)
+		r.Exactly(t, diagnostic.SeverityError, d.Severity, "case=%d", i)
+		r.Exactly(t, tc.expected.File, d.File, "case=%d", i)
+		r.Exactly(t, tc.expected.Line, d.Line, "case=%d", i)
+		r.Exactly(t, tc.expected.Column, d.Column, "case=%d", i)
+		r.Exactly(t, tc.expected.Directive, d.Directive, "case=%d", i)
+		r.Exactly(t, tc.expected.Function, d.Function, "case=%d", i)
+		r.Exactly(t, tc.expected.ImportStack, d.ImportStack, "case=%d", i)
+	}
+}
+
+func TestLint(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "partial.txt")
+	varFile := filepath.Join(dir, "yatt.var")
+	files := map[string]string{
+		partial: "{{undeclaredInPartial}}\n# yatt foreach 2\n{{index}}\n",
+		varFile: "# yatt var global = 1\n# yatt import nothing\n# yatt var broken\n",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	input := `# yatt var local = 1
+{{local}} {{global}} {{missing}} {{YATT_GLOBAL}} {{YATT_VARS}}
+{{upper(local, global)}} {{nosuch(local)}} {{name()}}
+{{var(inline, 1)}}{{inline}} {{var(nested, {{upper(local)}})}}{{nested}}
+# yatt foreach [ {{local}} ]
+{{index}}={{value}}
+# yatt if {{local}} == 1
+# yatt foreachend
+# yatt ifend
+# yatt else
+# yatt ignore
+{{ignored}}
+# yatt unknown
+# yatt ignoreend
+# yatt import ` + filepath.Join(dir, "missing.txt") + `
+# yatt import ` + partial + `
+# yatt import ` + partial + `
+# yatt unknown
+{{index}}
+`
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	c := New(l, []string{"# yatt"}, Options{})
+	r.NoError(t, c.InitGlobalVariablesByFiles(varFile))
+
+	type result struct {
+		file      string
+		line      int
+		column    int
+		directive string
+		function  string
+		message   string
+		stack     []diagnostic.Frame
+	}
+	results := func(ds []*diagnostic.Diagnostic) (ret []result) {
+		for _, d := range ds {
+			r.Exactly(t, diagnostic.SeverityError, d.Severity)
+			ret = append(ret, result{d.File, d.Line, d.Column, d.Directive, d.Function, d.Message, d.ImportStack})
+		}
+		return
+	}
+
+	ds, err := c.Lint("root.txt", strings.NewReader(input))
+	r.NoError(t, err)
+	stack := []diagnostic.Frame{{File: "root.txt", Line: 16}}
+	r.Exactly(t, []result{
+		{"root.txt", 3, 1, "", "upper", "exactly 1 args required, got 2", nil},
+		{"root.txt", 3, 26, "", "nosuch", "unknown function", nil},
+		{"root.txt", 7, 1, "if", "", "if is not closed before foreachend in line 8", nil},
+		{"root.txt", 9, 1, "ifend", "", "ifend without if", nil},
+		{"root.txt", 10, 1, "else", "", "else without if", nil},
+		{"root.txt", 15, 1, "import", "", "import " + filepath.Join(dir, "missing.txt") + " does not exist", nil},
+		{partial, 2, 1, "foreach", "", "unclosed foreach", stack},
+		{partial, 1, 1, "", "", `variable "undeclaredInPartial" is never declared`, stack},
+		{"root.txt", 18, 1, "unknown", "", "unknown preprocessor directive", nil},
+		{"root.txt", 2, 22, "", "", `variable "missing" is never declared`, nil},
+		// Only the len function knows YATT_VARS, as variable it is empty.
+		{"root.txt", 2, 50, "", "", `variable "YATT_VARS" is never declared`, nil},
+		{"root.txt", 19, 1, "", "", `variable "index" is never declared`, nil},
+	}, results(ds))
+
+	ds, err = c.LintVarFile(varFile)
+	r.NoError(t, err)
+	r.Exactly(t, []result{
+		{varFile, 2, 1, "import", "", "only var declarations are allowed in var files", nil},
+		{varFile, 3, 1, "var", "", "variable name or value must not be empty", nil},
+	}, results(ds))
+}
+
+func TestStrict(t *testing.T) {
+	t.Parallel()
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	input := `# yatt var host = localhost
+# yatt foreach [ {{host}}, {{host}} ]
+{{value}}:{{port}}
+# yatt foreachend
+`
+
+	type testCase struct {
+		input    string
+		expected string
+		hint     string
+	}
+
+	testCases := []testCase{
+		{input: "{{port}} {{unknown}}", expected: "80 \n"},
+		{input: input + "{{hsot}}", hint: `"hsot", did you mean "host"?`},
+		{input: strings.Replace(input, "{{value}}", "{{vaule}}", 1), hint: `"vaule", did you mean "value"?`},
+		{input: "{{porr}}", hint: `"porr", did you mean "port"?`},
+		{input: "{{completelyDifferent}}", hint: `"completelyDifferent"`},
+	}
+
+	for i, tc := range testCases {
+		c := New(l, []string{"# yatt"}, Options{LineEnding: []byte("\n"), Strict: tc.hint != ""})
+		r.NoError(t, c.SetGlobalVariable("port", "80"))
+		buf := &bytes.Buffer{}
+		err := c.Interpret(InterpreterFile{
+			Name: "strict.txt",
+			Buf:  buf,
+			RC:   io.NopCloser(strings.NewReader(tc.input)),
+		})
+		if tc.hint == "" {
+			r.NoError(t, err, "case=%d", i)
+			r.Exactly(t, tc.expected, buf.String(), "case=%d", i)
+			continue
+		}
+
+		r.ErrorIs(t, err, errUnresolvedVariable, "case=%d", i)
+		r.ErrorContains(t, err, tc.hint, "case=%d", i)
+		r.NotContains(t, err.Error(), "did you mean \"completelyDifferent", "case=%d", i)
+
+		var d *diagnostic.Diagnostic
+		r.ErrorAs(t, err, &d, "case=%d", i)
+		r.Exactly(t, "strict.txt", d.File, "case=%d", i)
+		r.NotZero(t, d.Line, "case=%d", i)
+	}
+}
+
+func TestDidYouMean(t *testing.T) {
+	t.Parallel()
+
+	candidates := []string{"host", "hosts", "port", "ghost", "path"}
+	r.Exactly(t, `, did you mean "host" or "hosts"?`, didYouMean("hots", candidates))
+	r.Exactly(t, `, did you mean "ghost" or "hosts" or "host"?`, didYouMean("ghosts", candidates))
+	r.Exactly(t, `, did you mean "port"?`, didYouMean("PORT", candidates))
+	r.Exactly(t, "", didYouMean("database", candidates))
+	r.Exactly(t, 3, editDistance("kitten", "sitting"))
+	r.Exactly(t, 1, editDistance("hsot", "host"))
+}
+
+func TestPassthrough(t *testing.T) {
+	t.Parallel()
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	type testCase struct {
+		input    string
+		expected string
+	}
+
+	testCases := []testCase{
+		{input: "image: {{ .Values.image }}", expected: "image: {{ .Values.image }}"},
+		{input: "sha: ${{ github.sha }}", expected: "sha: ${{ github.sha }}"},
+		{input: "{{ toYaml(.Values) | nindent 4 }}", expected: "{{ toYaml(.Values) | nindent 4 }}"},
+		{input: "{{host}}:{{ .Values.port }}", expected: "localhost:{{ .Values.port }}"},
+		{input: "{{upper(host)}} {{unknown}}", expected: "LOCALHOST {{unknown}}"},
+		{input: "{{upper({{host}})}}", expected: "LOCALHOST"},
+		{input: "{{- if .Values.x }}", expected: "{{- if .Values.x }}"},
+		{input: "{{host}} {{ unclosed", expected: "localhost {{ unclosed"},
+	}
+
+	for i, tc := range testCases {
+		// Passthrough takes precedence over strict.
+		c := New(l, []string{"# yatt"}, Options{LineEnding: []byte("\n"), Passthrough: true, Strict: true})
+		r.NoError(t, c.SetGlobalVariable("host", "localhost"))
+		buf := &bytes.Buffer{}
+		err := c.Interpret(InterpreterFile{
+			Name: "passthrough.txt",
+			Buf:  buf,
+			RC:   io.NopCloser(strings.NewReader(tc.input)),
+		})
+		r.NoError(t, err, "case=%d", i)
+		r.Exactly(t, tc.expected+"\n", buf.String(), "case=%d", i)
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	t.Parallel()
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	partial := filepath.Join(t.TempDir(), "partial.txt")
+	r.NoError(t, os.WriteFile(partial, []byte("partial {{index}}\n"), 0o644))
+
+	input := `first
+# yatt if {{enabled}}
+enabled
+# yatt else
+disabled
+# yatt ifend
+# yatt foreach 2
+{{index}}
+# yatt import ` + partial + `
+# yatt foreachend
+last`
+
+	c := New(l, []string{"# yatt"}, Options{LineEnding: []byte("\n")})
+	r.NoError(t, c.SetGlobalVariable("enabled", "true"))
+	sm := sourcemap.New()
+	buf := &bytes.Buffer{}
+	err := c.Interpret(InterpreterFile{
+		Name:      "main.txt",
+		Buf:       buf,
+		RC:        io.NopCloser(strings.NewReader(input)),
+		SourceMap: sm,
+	})
+	r.NoError(t, err)
+	r.Exactly(t, "first\nenabled\n0\npartial \n1\npartial \nlast\n", buf.String())
+
+	expected := []string{
+		"main.txt:1",
+		"main.txt:3 (branch in line 2)",
+		"main.txt:8 (foreach iteration 0)",
+		partial + ":1\n\timported by main.txt:9 (foreach iteration 0)",
+		"main.txt:8 (foreach iteration 1)",
+		partial + ":1\n\timported by main.txt:9 (foreach iteration 1)",
+		"main.txt:11",
+	}
+	r.Len(t, sm.Mappings, len(expected))
+	for i, mp := range sm.Mappings {
+		r.Exactly(t, i+1, mp.Output, "case=%d", i)
+		r.Exactly(t, expected[i], mp.String(), "case=%d", i)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+
+	input := `# yatt if {{env}} == dev
+dev
+# yatt else
+{{upper({{env}})}}
+# yatt ifend
+# yatt foreach 2
+{{index}}
+# yatt foreachend`
+
+	logs := &bytes.Buffer{}
+	c := New(zerolog.New(logs), []string{"# yatt"}, Options{LineEnding: []byte("\n"), Trace: true})
+	r.NoError(t, c.SetGlobalVariable("env", "prod"))
+	buf := &bytes.Buffer{}
+	err := c.Interpret(InterpreterFile{
+		Name: "trace.txt",
+		Buf:  buf,
+		RC:   io.NopCloser(strings.NewReader(input)),
+	})
+	r.NoError(t, err)
+	r.Exactly(t, "PROD\n0\n1\n", buf.String())
+
+	traced := logs.String()
+	for _, expected := range []string{
+		`{"level":"trace","mod":"core","file":"trace.txt","variable":"env","registry":"global","value":"prod","message":"variable lookup"}`,
+		`{"level":"trace","mod":"core","file":"trace.txt","line":1,"directive":"if","left":"prod","result":false,"operator":"==","right":"dev","taken":false,"message":"condition branch"}`,
+		`{"level":"trace","mod":"core","file":"trace.txt","line":3,"directive":"else","taken":true,"message":"condition branch"}`,
+		`{"level":"trace","mod":"core","file":"trace.txt","function":"upper","args":["prod"],"result":"PROD","message":"function call"}`,
+		`{"level":"trace","mod":"core","file":"trace.txt","line":6,"index":"1","message":"foreach iteration"}`,
+		`{"level":"trace","mod":"core","file":"trace.txt","variable":"index","registry":"foreach","value":"1","message":"variable lookup"}`,
+	} {
+		r.Contains(t, traced, expected)
+	}
+
+	// Without the trace option, nothing is traced.
+	logs.Reset()
+	c = New(zerolog.New(logs), []string{"# yatt"}, Options{LineEnding: []byte("\n")})
+	err = c.Interpret(InterpreterFile{
+		Name: "trace.txt",
+		Buf:  &bytes.Buffer{},
+		RC:   io.NopCloser(strings.NewReader(input)),
+	})
+	r.NoError(t, err)
+	r.Empty(t, logs.String())
+}
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	inner := filepath.Join(dir, "inner.txt")
+	outer := filepath.Join(dir, "outer.txt")
+	r.NoError(t, os.WriteFile(inner, []byte("{{upper(a)}}\n"), 0o600))
+	r.NoError(t, os.WriteFile(outer, []byte("# yatt import "+inner+"\n"), 0o600))
+
+	input := "# yatt import " + outer + "\n# yatt import " + inner + "\n# yatt foreach 2\n# yatt foreach 3\n{{lower(B)}}\n# yatt foreachend\n# yatt foreachend\n"
+	c := New(zerolog.Nop(), []string{"# yatt"}, Options{LineEnding: []byte("\n")})
+	err := c.Interpret(InterpreterFile{
+		Name: "stats.txt",
+		Buf:  &bytes.Buffer{},
+		RC:   io.NopCloser(strings.NewReader(input)),
+	})
+	r.NoError(t, err)
+	r.Exactly(t, Stats{
+		Imports:        3,
+		ImportDepth:    2,
+		LoopIterations: 8,
+		FunctionCalls:  map[string]int{"upper": 2, "lower": 6},
+	}, c.Stats())
+
+	// Every call to Interpret starts off with empty stats.
+	err = c.Interpret(InterpreterFile{
+		Name: "empty.txt",
+		Buf:  &bytes.Buffer{},
+		RC:   io.NopCloser(strings.NewReader("static\n")),
+	})
+	r.NoError(t, err)
+	r.Exactly(t, Stats{}, c.Stats())
+}
+
 func TestCondition(t *testing.T) {
 	t.Parallel()
 
@@ -918,6 +1709,60 @@ func TestConditionMalformed(t *testing.T) {
 	}
 }
 
+func TestCustomPrefixesAndDelimiters(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	partial := filepath.Join(dir, "partial.txt")
+	varFile := filepath.Join(dir, "yatt.var")
+	start := filepath.Join(dir, "start.txt")
+	r.NoError(t, os.WriteFile(partial, []byte("-- tpl var inner = partial\n<<inner>> {{ .Values.x }}\n"), 0o600))
+	r.NoError(t, os.WriteFile(varFile, []byte("-- tpl var global = global\n"), 0o600))
+	r.NoError(t, os.WriteFile(start, []byte(`-- tpl var a = A
+-- tpl var b = B
+<<global>> <<upper(a)>> <<add(1, <<mult(2, 3)>>)>>
+-- tpl foreach [ <<a>>, <<b>> ]
+<<index>>=<<value>>
+-- tpl foreachend
+-- tpl if <<a>> == A
+# yatt if this is no directive anymore
+-- tpl ifend
+-- tpl import `+partial+`
+`), 0o600))
+
+	opts := Options{
+		TemplateStart: []byte("<<"),
+		TemplateEnd:   []byte(">>"),
+	}
+	prefixes := []string{"--", "-- tpl"}
+	r.NoError(t, Validate(prefixes, opts))
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	c := New(l, prefixes, opts)
+	r.NoError(t, c.InitGlobalVariablesByFiles(varFile))
+	r.NoError(t, c.ImportPathCheckCyclicDependencies(start))
+	r.Exactly(t, []string{partial}, c.Dependencies(start))
+
+	rc, err := os.Open(start)
+	r.NoError(t, err)
+	buf := &bytes.Buffer{}
+	r.NoError(t, c.Interpret(InterpreterFile{
+		Name: start,
+		Buf:  buf,
+		RC:   rc,
+	}))
+	r.Exactly(t, "global A 7\n0=A\n1=B\n# yatt if this is no directive anymore\npartial {{ .Values.x }}\n", buf.String())
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	r.NoError(t, Validate(DefaultPrefixes(), Options{}))
+	r.ErrorIs(t, Validate([]string{" "}, Options{}), errEmptyPrefix)
+	r.ErrorIs(t, Validate(nil, Options{TemplateStart: []byte("%"), TemplateEnd: []byte("%")}), errDelimitersOverlap)
+	r.ErrorIs(t, Validate(nil, Options{TemplateStart: []byte("}")}), errDelimitersOverlap)
+}
+
 //
 // Helper
 //
@@ -937,7 +1782,40 @@ func interpretString(t *testing.T, input string) *bytes.Buffer {
 	return buf
 }
 
+// flattenVars returns the "path=value" pairs of vars, structured variables are flattened to the paths of their nested values.
+func flattenVars(vars []common.Variable) (flat []string) {
+	for _, v := range vars {
+		flat = append(flat, flattenVar(v.Name(), v)...)
+	}
+	return
+}
+
+func flattenVar(path string, v common.Variable) (flat []string) {
+	s, ok := v.(common.Structure)
+	if !ok {
+		return []string{path + "=" + v.Value()}
+	}
+	for _, child := range s.Children() {
+		childPath := path + "." + child.Name()
+		if s.IsList() {
+			childPath = path + "[" + child.Name() + "]"
+		}
+		flat = append(flat, flattenVar(childPath, child)...)
+	}
+	return
+}
+
 func floatCompareOK(expected, actual float64) bool {
 	return (math.IsNaN(expected) && math.IsNaN(actual)) ||
 		math.Abs(expected-actual) <= floatThreshold
 }
+
+func TestTrimLineKeepsPrefix(t *testing.T) {
+	t.Parallel()
+
+	// The prefix has spare capacity, which must not be written to, since it may be shared between forks.
+	backing := []byte("# yattX")
+	prefix := backing[:6]
+	r.Exactly(t, []byte("import a"), trimLine([]byte("  # yatt import a"), prefix))
+	r.Exactly(t, "# yattX", string(backing))
+}
@@ -1,16 +1,16 @@
 package core
 
 import (
+	"slices"
 	"sync"
 )
 
 type dependencies map[string][]string
 
-// dependencyResolver stores information about a single import file.
+// dependencyResolver records the imports of every file.
 type dependencyResolver struct {
-	deps    dependencies
-	visited []string
-	mx      *sync.Mutex
+	deps dependencies
+	mx   *sync.Mutex
 }
 
 func newDependencyResolver() dependencyResolver {
@@ -24,42 +24,92 @@ func (d *dependencyResolver) addDependency(origin, destination string) {
 	d.mx.Lock()
 	defer d.mx.Unlock()
 
+	for _, dep := range d.deps[origin] {
+		if dep == destination {
+			return
+		}
+	}
 	d.deps[origin] = append(d.deps[origin], destination)
 }
 
-func (d *dependencyResolver) CheckForCyclicDependencies(start, destination string) (cyclic bool) {
-	d.visited = make([]string, 0)
-
-	return d.dependenciesAreCyclic(start, destination)
-}
-
-// dependenciesAreCyclic traverses the whole dependency tree to look if it contains a cycle.
-func (d *dependencyResolver) dependenciesAreCyclic(start, destination string) (cyclic bool) {
-	// Same files cannot be imported.
-	if start == destination {
-		return true
-	}
-
+// findCycle searches the dependencies reachable from start for a cycle by a depth-first search.
+// The cycle is returned as path which starts and ends with the same file, e.g. [a b c a].
+// Files which are imported multiple times without forming a cycle (diamonds) are allowed.
+func (d *dependencyResolver) findCycle(start string) (cycle []string) {
 	d.mx.Lock()
-	deps := d.deps[destination]
-	d.mx.Unlock()
-	for _, dep := range deps {
-		if dep == start || dep == destination {
+	defer d.mx.Unlock()
+
+	const (
+		visiting = iota + 1
+		visited
+	)
+	var (
+		states = make(map[string]int)
+		path   = make([]string, 0)
+		visit  func(file string) bool
+	)
+	visit = func(file string) bool {
+		switch states[file] {
+		case visited:
+			// Already checked through another import.
+			return false
+		case visiting:
+			// The file is part of the current path, which is therefore cyclic.
+			idx := slices.Index(path, file)
+			cycle = append(slices.Clone(path[idx:]), file)
 			return true
 		}
-		for _, v := range d.visited {
-			if dep == v {
-				// Dependency may not be part of the already visited route.
+
+		states[file] = visiting
+		path = append(path, file)
+		for _, dep := range d.deps[file] {
+			if visit(dep) {
 				return true
 			}
 		}
+		path = path[:len(path)-1]
+		states[file] = visited
+		return false
+	}
 
-		d.visited = append(d.visited, destination)
-		cyclic = d.dependenciesAreCyclic(destination, dep)
-		if cyclic {
-			return
+	visit(start)
+	return
+}
+
+// importsOf returns the files imported directly by origin, without duplicates.
+func (d *dependencyResolver) importsOf(origin string) (imports []string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	seen := make(map[string]struct{})
+	for _, dep := range d.deps[origin] {
+		if _, ok := seen[dep]; ok {
+			continue
 		}
+		seen[dep] = struct{}{}
+		imports = append(imports, dep)
 	}
+	return
+}
 
+// dependenciesOf returns every direct and transitive dependency recorded for origin.
+func (d *dependencyResolver) dependenciesOf(origin string) (deps []string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	seen := map[string]struct{}{origin: {}}
+	queue := []string{origin}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, dep := range d.deps[cur] {
+			if _, ok := seen[dep]; ok {
+				continue
+			}
+			seen[dep] = struct{}{}
+			deps = append(deps, dep)
+			queue = append(queue, dep)
+		}
+	}
 	return
 }
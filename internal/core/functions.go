@@ -47,6 +47,50 @@ const (
 	functionNameTimeNow = "now"
 )
 
+// arity is the amount of args a function accepts.
+// A negative max allows any amount of args above min.
+type arity struct {
+	min int
+	max int
+}
+
+// functionArity contains the arity of every function known by executeFunction.
+var functionArity = map[string]arity{
+	functionNameCryptSHA1:   {1, 1},
+	functionNameCryptSHA256: {1, 1},
+	functionNameCryptSHA512: {1, 1},
+	functionNameCryptMD5:    {1, 1},
+
+	functionNameInternalEnv:          {1, 1},
+	functionNameInternalFileBaseName: {0, 0},
+	functionNameInternalFileName:     {0, 0},
+	functionNameInternalVar:          {2, -1},
+
+	functionNameMathAdd:   {2, -1},
+	functionNameMathSub:   {2, -1},
+	functionNameMathMult:  {2, -1},
+	functionNameMathDiv:   {2, -1},
+	functionNameMathPow:   {2, 2},
+	functionNameMathSqrt:  {1, 1},
+	functionNameMathRound: {1, 1},
+	functionNameMathCeil:  {1, -1},
+	functionNameMathFloor: {1, -1},
+	functionNameMathFixed: {2, 2},
+	functionNameMathMax:   {2, -1},
+	functionNameMathMin:   {2, -1},
+	functionNameMathMod:   {2, -1},
+
+	functionNameStringCapitalize: {1, 1},
+	functionNameStringRepeat:     {2, 2},
+	functionNameStringReplace:    {3, 3},
+	functionNameStringSplit:      {3, 3},
+	functionNameStringToLower:    {1, 1},
+	functionNameStringToUpper:    {1, 1},
+	functionNameStringLength:     {1, 1},
+
+	functionNameTimeNow: {1, 1},
+}
+
 func (c *Core) executeFunction(funcName parserFunc, fileName string, args [][]byte, additionalVars []common.Variable) (ret []byte, err error) {
 	defer func() {
 		if err != nil {
@@ -78,14 +122,16 @@ func (c *Core) executeFunction(funcName parserFunc, fileName string, args [][]by
 			c.setLocalVar(filepath.Clean(fileName), common.NewVar(string(name), string(value)))
 			return nil
 		}
-		if c.feb.StateIndex() > -1 {
+		// Imported files must not set variables inside the loops and conditions of their importer.
+		scope, imported := c.importScope()
+		if c.feb.StateIndex() > -1 && (!imported || c.feb.StateIndex() != scope.foreachIdx) {
 			varSetter = func(name, value []byte) error {
 				reg := strconv.Itoa(c.feb.StateIndex())
 				c.setForeachVar(reg, common.NewVar(string(name), string(value)))
 				return nil
 			}
 		}
-		if c.cb.StateIndex() > -1 {
+		if c.cb.StateIndex() > -1 && (!imported || c.cb.StateIndex() != scope.conditionIdx) {
 			varSetter = func(name, value []byte) error {
 				reg := strconv.Itoa(c.cb.StateIndex())
 				c.setConditionVar(reg, common.NewVar(string(name), string(value)))
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// lineReaderBufSize is the initial read buffer size, lines may exceed it.
+const lineReaderBufSize = 64 * 1024
+
+// lineReader reads lines of arbitrary length.
+// Unlike bufio.Scanner, lines are not limited to a maximum size,
+// only the current line is held in memory.
+type lineReader struct {
+	r    *bufio.Reader
+	line []byte
+	err  error
+}
+
+func newLineReader(r io.Reader) *lineReader {
+	return &lineReader{
+		r: bufio.NewReaderSize(r, lineReaderBufSize),
+	}
+}
+
+// Scan advances to the next line, which is then available through Bytes.
+// It returns false at the end of the input or on errors.
+func (lr *lineReader) Scan() bool {
+	var line []byte
+	for {
+		chunk, err := lr.r.ReadSlice('\n')
+		// ReadSlice returns its internal buffer, the chunk needs to be copied.
+		line = append(line, chunk...)
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				lr.err = err
+				return false
+			}
+			if len(line) == 0 {
+				return false
+			}
+		}
+		break
+	}
+
+	// Drop the line ending, just like bufio.ScanLines.
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	lr.line = bytes.TrimSuffix(line, []byte{'\r'})
+	return true
+}
+
+// Bytes returns the current line without its line ending.
+// The returned slice is not reused by subsequent calls to Scan.
+func (lr *lineReader) Bytes() []byte {
+	return lr.line
+}
+
+// Err returns the first non-EOF error which occurred while reading.
+func (lr *lineReader) Err() error {
+	return lr.err
+}
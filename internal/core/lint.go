@@ -0,0 +1,436 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xiroxasx/yatt/internal/common"
+	"github.com/xiroxasx/yatt/internal/diagnostic"
+)
+
+// foreachVariables are created for every iteration of a foreach loop.
+var foreachVariables = []string{"index", "key", "value", "line"}
+
+// linter checks templates without rendering them.
+type linter struct {
+	c *Core
+	// linted contains the files which have already been linted, to lint shared imports only once.
+	linted map[string]struct{}
+	ds     []*diagnostic.Diagnostic
+}
+
+// lintBlock is an opened foreach, ignore or if block.
+type lintBlock struct {
+	name    string
+	lineNum int
+	column  int
+}
+
+// lintRef is a variable referenced by a template.
+type lintRef struct {
+	name      string
+	lineNum   int
+	column    int
+	inForeach bool
+}
+
+// Lint checks the template read from r and all of its imports without rendering them.
+// The name is used as file name of the reported diagnostics.
+// Variables are only checked against the global variables which are already loaded.
+func (c *Core) Lint(name string, r io.Reader) (ds []*diagnostic.Diagnostic, err error) {
+	l := &linter{
+		c:      c,
+		linted: make(map[string]struct{}),
+	}
+	err = l.lint(name, r, nil, nil)
+	return l.ds, err
+}
+
+// LintFile checks the template at path and all of its imports without rendering them.
+func (c *Core) LintFile(path string) (ds []*diagnostic.Diagnostic, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	return c.Lint(filepath.Clean(path), f)
+}
+
+// LintVarFile checks the declarations of the var file arg, which may have a format prefix.
+// Structured var files are only checked for syntax errors.
+func (c *Core) LintVarFile(arg string) (ds []*diagnostic.Diagnostic, err error) {
+	path, format := splitVarFile(arg)
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	l := &linter{c: c}
+	if format != VarFormatYatt {
+		var cont []byte
+		cont, err = io.ReadAll(f)
+		if err != nil {
+			return
+		}
+		_, pErr := c.parseVarFile(format, cont)
+		if pErr != nil {
+			l.report(path, 0, 0, "", "", nil, fmt.Sprintf("invalid %s var file: %v", format, pErr))
+		}
+		return l.ds, nil
+	}
+
+	lr := newLineReader(f)
+	lineNum := 0
+	for lr.Scan() {
+		lineNum++
+		line := lr.Bytes()
+		prefix := c.matchedPrefixToken(line)
+		if prefix == nil {
+			continue
+		}
+
+		column := bytes.Index(line, prefix) + 1
+		split := bytes.Split(trimLine(line, prefix), []byte{' '})
+		if string(split[0]) != directiveNameVariable {
+			l.report(path, lineNum, column, string(split[0]), "", nil, "only var declarations are allowed in var files")
+			continue
+		}
+		l.lintVariable(path, lineNum, column, split[1:], nil)
+	}
+	return l.ds, lr.Err()
+}
+
+// lint checks the template read from r.
+// params contains the names of the import arguments which are visible inside the template.
+func (l *linter) lint(name string, r io.Reader, stack []diagnostic.Frame, params map[string]struct{}) (err error) {
+	l.linted[name] = struct{}{}
+
+	var (
+		lineNum  int
+		blocks   = make([]lintBlock, 0)
+		declared = make(map[string]struct{})
+		refs     = make([]lintRef, 0)
+		lr       = newLineReader(r)
+	)
+	for p := range params {
+		declared[p] = struct{}{}
+	}
+	inBlock := func(name string) bool {
+		for _, b := range blocks {
+			if b.name == name {
+				return true
+			}
+		}
+		return false
+	}
+	closeBlock := func(opening, closing string, column int) {
+		idx := -1
+		for j := len(blocks) - 1; j >= 0; j-- {
+			if blocks[j].name == opening {
+				idx = j
+				break
+			}
+		}
+		if idx < 0 {
+			l.report(name, lineNum, column, closing, "", stack, fmt.Sprintf("%s without %s", closing, opening))
+			return
+		}
+
+		// Blocks opened after the closed one are unbalanced.
+		for _, b := range blocks[idx+1:] {
+			l.report(name, b.lineNum, b.column, b.name, "", stack, fmt.Sprintf("%s is not closed before %s in line %d", b.name, closing, lineNum))
+		}
+		blocks = blocks[:idx]
+	}
+	checkTokens := func(line []byte, offset int) {
+		for _, t := range l.tokens(line) {
+			if t.function != "" {
+				l.lintFunction(name, lineNum, offset+t.column, t, stack, declared)
+				continue
+			}
+			refs = append(refs, lintRef{
+				name:      t.content,
+				lineNum:   lineNum,
+				column:    offset + t.column,
+				inForeach: inBlock(directiveNameForeach),
+			})
+		}
+	}
+
+	for lr.Scan() {
+		lineNum++
+		line := lr.Bytes()
+		prefix := l.c.matchedPrefixToken(line)
+		if prefix == nil {
+			if !inBlock(directiveNameIgnore) {
+				checkTokens(line, 0)
+			}
+			continue
+		}
+
+		column := bytes.Index(line, prefix) + 1
+		statement := trimLine(line, prefix)
+		split := bytes.Split(statement, []byte{' '})
+		directive, args := string(split[0]), split[1:]
+		// Arguments start after the directive name.
+		argsOffset := bytes.Index(line, statement) + len(split[0])
+
+		if inBlock(directiveNameIgnore) && directive != directiveNameIgnore && directive != directiveNameIgnoreEnd {
+			continue
+		}
+
+		switch directive {
+		case directiveNameForeach:
+			if len(args) == 0 {
+				l.report(name, lineNum, column, directive, "", stack, "at least 1 arg expected")
+			}
+			checkTokens(statement[len(split[0]):], argsOffset)
+			blocks = append(blocks, lintBlock{name: directive, lineNum: lineNum, column: column})
+
+		case directiveNameIgnore:
+			blocks = append(blocks, lintBlock{name: directive, lineNum: lineNum, column: column})
+
+		case directiveNameConditionIf:
+			if len(args) == 0 {
+				l.report(name, lineNum, column, directive, "", stack, "at least 1 arg expected")
+			}
+			checkTokens(statement[len(split[0]):], argsOffset)
+			blocks = append(blocks, lintBlock{name: directive, lineNum: lineNum, column: column})
+
+		case directiveNameConditionIfElse, directiveNameConditionElse:
+			if len(blocks) == 0 || blocks[len(blocks)-1].name != directiveNameConditionIf {
+				l.report(name, lineNum, column, directive, "", stack, fmt.Sprintf("%s without %s", directive, directiveNameConditionIf))
+			}
+			if directive == directiveNameConditionElse && len(args) > 0 {
+				l.report(name, lineNum, column, directive, "", stack, "no args expected")
+			}
+			if directive == directiveNameConditionIfElse {
+				if len(args) == 0 {
+					l.report(name, lineNum, column, directive, "", stack, "at least 1 arg expected")
+				}
+				checkTokens(statement[len(split[0]):], argsOffset)
+			}
+
+		case directiveNameForeachEnd:
+			closeBlock(directiveNameForeach, directive, column)
+
+		case directiveNameIgnoreEnd:
+			closeBlock(directiveNameIgnore, directive, column)
+
+		case directiveNameConditionEnd:
+			if len(args) > 0 {
+				l.report(name, lineNum, column, directive, "", stack, "no args expected")
+			}
+			closeBlock(directiveNameConditionIf, directive, column)
+
+		case directiveNameImport:
+			checkTokens(statement[len(split[0]):], argsOffset)
+			err = l.lintImport(name, lineNum, column, statement[len(split[0]):], stack, params)
+			if err != nil {
+				return
+			}
+
+		case directiveNameVariable:
+			v := l.lintVariable(name, lineNum, column, args, stack)
+			if v != "" {
+				declared[v] = struct{}{}
+			}
+
+		default:
+			l.report(name, lineNum, column, directive, "", stack, "unknown preprocessor directive")
+		}
+	}
+	err = lr.Err()
+	if err != nil {
+		return
+	}
+
+	for _, b := range blocks {
+		l.report(name, b.lineNum, b.column, b.name, "", stack, fmt.Sprintf("unclosed %s", b.name))
+	}
+
+	for _, ref := range refs {
+		// Unknown variables are kept as they are in passthrough mode.
+		if l.c.opts.Passthrough || l.isDeclared(ref, declared) {
+			continue
+		}
+		l.report(name, ref.lineNum, ref.column, "", "", stack, fmt.Sprintf("variable %q is never declared", ref.name))
+	}
+	return
+}
+
+func (l *linter) lintImport(name string, lineNum, column int, rawArgs []byte, stack []diagnostic.Frame, params map[string]struct{}) (err error) {
+	path, importArgs, pErr := l.c.parseImport(rawArgs)
+	if pErr != nil {
+		l.report(name, lineNum, column, directiveNameImport, "", stack, pErr.Error())
+		return
+	}
+
+	// Import arguments are visible inside the imported file and its imports.
+	childParams := make(map[string]struct{}, len(params)+len(importArgs))
+	for p := range params {
+		childParams[p] = struct{}{}
+	}
+	for _, arg := range importArgs {
+		childParams[arg.Name()] = struct{}{}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			l.report(name, lineNum, column, directiveNameImport, "", stack, fmt.Sprintf("import %s does not exist", path))
+			return nil
+		}
+		return
+	}
+	defer f.Close()
+
+	_, ok := l.linted[path]
+	if ok {
+		// Shared imports and cycles only need to be linted once.
+		return
+	}
+
+	importStack := append([]diagnostic.Frame{{File: name, Line: lineNum}}, stack...)
+	return l.lint(path, f, importStack, childParams)
+}
+
+// lintVariable checks the var declaration args and returns the declared name.
+func (l *linter) lintVariable(name string, lineNum, column int, args [][]byte, stack []diagnostic.Frame) string {
+	v := common.VarFromArg(bytes.Join(args, []byte{' '}))
+	if v.Name() == "" || v.Value() == "" {
+		l.report(name, lineNum, column, directiveNameVariable, "", stack, errEmptyVariableParameter.Error())
+		return ""
+	}
+	return v.Name()
+}
+
+func (l *linter) lintFunction(name string, lineNum, column int, t lintToken, stack []diagnostic.Frame, declared map[string]struct{}) {
+	fncName := strings.ToLower(t.function)
+	arity, ok := functionArity[fncName]
+	if !ok {
+		if !l.c.opts.Passthrough {
+			l.report(name, lineNum, column, "", t.function, stack, "unknown function")
+		}
+		return
+	}
+	if fncName == functionNameInternalVar && t.args > 0 {
+		_, args := unwrapFunc([]byte(t.content))
+		declared[string(args[0])] = struct{}{}
+	}
+	if t.nested {
+		// The args are only known after the nested tokens are resolved.
+		return
+	}
+
+	switch {
+	case arity.max < 0 && t.args < arity.min:
+		l.report(name, lineNum, column, "", t.function, stack, fmt.Sprintf("at least %d args required, got %d", arity.min, t.args))
+	case arity.max >= 0 && (t.args < arity.min || t.args > arity.max):
+		l.report(name, lineNum, column, "", t.function, stack, fmt.Sprintf("exactly %d args required, got %d", arity.min, t.args))
+	}
+}
+
+func (l *linter) isDeclared(ref lintRef, declared map[string]struct{}) bool {
+	_, ok := declared[ref.name]
+	if ok {
+		return true
+	}
+	if ref.inForeach {
+		for _, name := range foreachVariables {
+			if ref.name == name {
+				return true
+			}
+		}
+	}
+	// The global key refers to all global variables, or with a "_<var file>" suffix to the ones of a var file.
+	if ref.name == variableGlobalKey || strings.HasPrefix(ref.name, variableGlobalKey+"_") {
+		return true
+	}
+	if l.c.varLookupGlobal(ref.name).Name() != "" || l.c.varLookupOverride(ref.name).Name() != "" {
+		return true
+	}
+
+	// Nested values are declared along with their structured variable.
+	root, path := common.SplitPath(ref.name)
+	if len(path) == 0 {
+		return false
+	}
+	ref.name = root
+	return l.isDeclared(ref, declared)
+}
+
+func (l *linter) report(file string, lineNum, column int, directive, function string, stack []diagnostic.Frame, msg string) {
+	l.ds = append(l.ds, &diagnostic.Diagnostic{
+		Severity:    diagnostic.SeverityError,
+		File:        file,
+		Line:        lineNum,
+		Column:      column,
+		Directive:   directive,
+		Function:    function,
+		Message:     msg,
+		ImportStack: stack,
+	})
+}
+
+// lintToken is a variable or function token inside a line.
+type lintToken struct {
+	content  string
+	column   int
+	function string
+	args     int
+	// nested is true if the token contains other tokens.
+	nested bool
+}
+
+// tokens returns all tokens of line, inner tokens first.
+func (l *linter) tokens(line []byte) (ts []lintToken) {
+	start, end := l.c.templateStart, l.c.templateEnd
+	opened := make([]int, 0)
+	for pos := 0; pos < len(line); {
+		rest := line[pos:]
+		startIdx := bytes.Index(rest, start)
+		endIdx := bytes.Index(rest, end)
+		if startIdx < 0 && endIdx < 0 {
+			break
+		}
+
+		if startIdx >= 0 && (endIdx < 0 || startIdx < endIdx) {
+			opened = append(opened, pos+startIdx)
+			pos += startIdx + len(start)
+			continue
+		}
+
+		pos += endIdx + len(end)
+		if len(opened) == 0 {
+			continue
+		}
+		tokenStart := opened[len(opened)-1]
+		opened = opened[:len(opened)-1]
+
+		content := line[tokenStart+len(start) : pos-len(end)]
+		t := lintToken{
+			content: string(content),
+			column:  tokenStart + 1,
+			nested:  bytes.Contains(content, start),
+		}
+		fnc, args := unwrapFunc(content)
+		if len(fnc) > 0 {
+			t.function = fnc.string()
+			t.args = len(args)
+			if len(args) == 1 && len(args[0]) == 0 {
+				t.args = 0
+			}
+		}
+		ts = append(ts, t)
+	}
+	return
+}
@@ -3,11 +3,11 @@ package core
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
 	"path/filepath"
 
 	"github.com/xiroxasx/yatt/internal/common"
+	"github.com/xiroxasx/yatt/internal/diagnostic"
 )
 
 const (
@@ -29,8 +29,11 @@ type PreprocessorDirective struct {
 	args           [][]byte
 	indent         []byte
 	lineNum        int
+	column         int
 	additionalVars []common.Variable
 	buf            *bytes.Buffer
+	// rawArgs is the unsplit statement after the directive name.
+	rawArgs []byte
 }
 
 func newPreprocessorDirective(name, fileName string, lineNum int, args [][]byte, indent []byte, additionalVars []common.Variable) *PreprocessorDirective {
@@ -55,11 +58,9 @@ func (c *Core) Preprocess(pd *PreprocessorDirective, importPathFunc func(pd *Pre
 }
 
 func (c *Core) preprocess(importPathFunc func(pd *PreprocessorDirective) error, pd *PreprocessorDirective) (err error) {
-	callID := fmt.Sprintf("%s: %s: %d", pd.fileName, pd.name, pd.lineNum)
 	defer func() {
 		if err != nil {
-			err = fmt.Errorf("%s: %v", callID, err)
-			return
+			err = directiveDiagnostic(err, pd.name, pd.column)
 		}
 	}()
 
@@ -99,6 +100,39 @@ func (c *Core) preprocess(importPathFunc func(pd *PreprocessorDirective) error,
 	}
 }
 
+// directiveDiagnostic converts err into a diagnostic of the named directive.
+// Diagnostics of nested lines or imports are returned as they are, since they are located more precisely.
+func directiveDiagnostic(err error, name string, column int) *diagnostic.Diagnostic {
+	var d *diagnostic.Diagnostic
+	if errors.As(err, &d) {
+		return d
+	}
+
+	d = diagnostic.New(err)
+	d.Directive = name
+	d.Column = column
+	return d
+}
+
+// locate sets the file and line of the diagnostic of err, if they are still unknown.
+// The indent is added to the column, since it is cut off before the line is interpreted.
+func locate(err error, fileName string, lineNum, indent int) *diagnostic.Diagnostic {
+	var d *diagnostic.Diagnostic
+	if !errors.As(err, &d) {
+		d = diagnostic.New(err)
+	}
+	if d.File != "" {
+		return d
+	}
+
+	d.File = fileName
+	d.Line = lineNum
+	if d.Column > 0 {
+		d.Column += indent
+	}
+	return d
+}
+
 func isConditionControlDirective(name string) bool {
 	switch name {
 	case directiveNameConditionIf,
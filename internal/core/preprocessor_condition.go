@@ -16,11 +16,12 @@ func (c *Core) conditionIf(pd *PreprocessorDirective) (err error) {
 	for i, arg := range pd.args {
 		condArgs[i] = arg
 	}
-	eval, err := c.cb.IsTrue(pd.fileName, condArgs, c, pd.additionalVars...)
+	ev, err := c.cb.IsTrue(pd.fileName, condArgs, c, pd.additionalVars...)
 	if err != nil {
 		return fmt.Errorf("condition isTrue: %v", err)
 	}
-	c.cb.PushIf(pd.fileName, pd.lineNum, eval)
+	c.cb.PushIf(pd.fileName, pd.lineNum, ev.Result)
+	c.traceBranch(pd, &ev)
 	return
 }
 
@@ -34,25 +35,40 @@ func (c *Core) conditionIfElse(pd *PreprocessorDirective) (err error) {
 		return err
 	}
 	if !canEvaluate {
-		return c.cb.IfElse(false)
+		err = c.cb.IfElse(pd.lineNum, false)
+		if err != nil {
+			return
+		}
+		c.traceBranch(pd, nil)
+		return
 	}
 
 	condArgs := make([]condition.Arg, len(pd.args))
 	for i, arg := range pd.args {
 		condArgs[i] = arg
 	}
-	eval, err := c.cb.IsTrue(pd.fileName, condArgs, c, pd.additionalVars...)
+	ev, err := c.cb.IsTrue(pd.fileName, condArgs, c, pd.additionalVars...)
 	if err != nil {
 		return fmt.Errorf("condition isTrue: %v", err)
 	}
-	return c.cb.IfElse(eval)
+	err = c.cb.IfElse(pd.lineNum, ev.Result)
+	if err != nil {
+		return
+	}
+	c.traceBranch(pd, &ev)
+	return
 }
 
 func (c *Core) conditionElse(pd *PreprocessorDirective) (err error) {
 	if len(pd.args) > 0 {
 		return errors.New("no args expected")
 	}
-	return c.cb.Else()
+	err = c.cb.Else(pd.lineNum)
+	if err != nil {
+		return
+	}
+	c.traceBranch(pd, nil)
+	return
 }
 
 func (c *Core) conditionEnd(pd *PreprocessorDirective) (err error) {
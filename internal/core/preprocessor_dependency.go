@@ -1,13 +1,28 @@
 package core
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/xiroxasx/yatt/internal/diagnostic"
 )
 
+// Dependencies returns all files imported by path, directly or through other imports.
+// Only dependencies recorded by ImportPathCheckCyclicDependencies are taken into account.
+func (c *Core) Dependencies(path string) []string {
+	return c.depsResolver.dependenciesOf(filepath.Clean(path))
+}
+
+// Imports returns the files imported directly by path, in order of their first import statement.
+// Only dependencies recorded by ImportPathCheckCyclicDependencies are taken into account.
+func (c *Core) Imports(path string) []string {
+	return c.depsResolver.importsOf(filepath.Clean(path))
+}
+
 func (c *Core) ImportPathCheckCyclicDependencies(startPath string) (err error) {
 	file, err := os.Open(startPath)
 	if err != nil {
@@ -15,6 +30,9 @@ func (c *Core) ImportPathCheckCyclicDependencies(startPath string) (err error) {
 	}
 	defer func() {
 		cErr := file.Close()
+		if cErr == nil {
+			return
+		}
 		if err == nil {
 			err = cErr
 			return
@@ -22,110 +40,136 @@ func (c *Core) ImportPathCheckCyclicDependencies(startPath string) (err error) {
 		c.l.Err(cErr).Str("file", startPath).Msg("closing file reader")
 	}()
 
+	return c.CheckCyclicDependencies(startPath, file)
+}
+
+// importStatement is an import directive found inside a template.
+type importStatement struct {
+	path    string
+	lineNum int
+	column  int
+	err     error
+}
+
+// CheckCyclicDependencies checks the imports read from r and all transitive imports for cyclic dependencies.
+// Files which are imported multiple times without forming a cycle (diamonds) are allowed.
+// The name is used as the origin of the found imports.
+func (c *Core) CheckCyclicDependencies(name string, r io.Reader) (err error) {
+	imports, err := c.importStatements(r)
+	if err != nil {
+		return
+	}
+
 	var (
-		// Limits reads to 65536 bytes per line.
-		scanner = bufio.NewScanner(file)
-		ln      int
+		// Every file is read once, its imports are checked for cycles afterwards.
+		statements = map[string][]importStatement{name: imports}
+		// stacks contains the import statements which led to every file, innermost first.
+		stacks = map[string][]diagnostic.Frame{name: nil}
+		graph  = newDependencyResolver()
+		queue  = []string{name}
 	)
-	for scanner.Scan() {
-		err = scanner.Err()
-		if err != nil {
-			return
-		}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
 
-		ln++
-		line := bytes.TrimSpace(scanner.Bytes())
-		prefix := c.matchedPrefixToken(line)
-		if len(prefix) == 0 {
-			continue
-		}
+		for _, imp := range statements[cur] {
+			if imp.err != nil {
+				return importDiagnostic(imp.err, cur, imp, stacks[cur])
+			}
 
-		statement := trimLine(line, prefix)
-		split := bytes.Split(statement, []byte{' '})
-		if len(split) == 0 {
-			continue
-		}
+			graph.addDependency(cur, imp.path)
+			c.depsResolver.addDependency(cur, imp.path)
+			_, ok := statements[imp.path]
+			if ok {
+				continue
+			}
 
-		preprocessor := bytes.TrimSpace(split[0])
-		if string(preprocessor) != directiveNameImport {
-			continue
+			var nested []importStatement
+			nested, err = c.importFileStatements(imp.path)
+			if err != nil {
+				return importDiagnostic(err, cur, imp, stacks[cur])
+			}
+			statements[imp.path] = nested
+			stacks[imp.path] = append([]diagnostic.Frame{{File: cur, Line: imp.lineNum}}, stacks[cur]...)
+			queue = append(queue, imp.path)
 		}
+	}
 
-		pd := &PreprocessorDirective{
-			name:     string(preprocessor),
-			fileName: startPath,
-			args:     split[1:],
-			buf:      &bytes.Buffer{},
-			lineNum:  ln,
-		}
-		err = c.walkDependency(pd)
-		if err != nil {
-			return
+	cycle := graph.findCycle(name)
+	if len(cycle) == 0 {
+		return
+	}
+
+	// Report the cycle at the import statement which closes it.
+	from, to := cycle[len(cycle)-2], cycle[len(cycle)-1]
+	for _, imp := range statements[from] {
+		if imp.path == to {
+			err = fmt.Errorf("%w: %s", errDependencyCyclic, strings.Join(cycle, " -> "))
+			return importDiagnostic(err, from, imp, stacks[from])
 		}
 	}
 	return
 }
 
-func (c *Core) walkDependency(pd *PreprocessorDirective) (err error) {
-	if len(pd.args) != 1 {
-		return errDependencyUnknownSyntax
-	}
-
-	sourcePath := pd.fileName
-	importingPath := filepath.Clean(string(pd.args[0]))
-	c.depsResolver.addDependency(sourcePath, importingPath)
-	importFile, err := os.Open(importingPath)
+// importFileStatements returns the import directives of the file at path.
+func (c *Core) importFileStatements(path string) (imports []importStatement, err error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return
 	}
 	defer func() {
-		cErr := importFile.Close()
+		cErr := f.Close()
 		if cErr != nil {
 			if err == nil {
 				err = cErr
 				return
 			}
-			c.l.Err(cErr).Str("path", importingPath).Msg("closing dependency file on defer")
+			c.l.Err(cErr).Str("path", path).Msg("closing dependency file on defer")
 		}
 	}()
 
-	// Limits reads to 65536 bytes per line.
-	scanner := bufio.NewScanner(importFile)
-	for scanner.Scan() {
-		err = scanner.Err()
-		if err != nil {
-			return
-		}
+	return c.importStatements(f)
+}
 
-		line := bytes.TrimSpace(scanner.Bytes())
+// importStatements returns the import directives read from r.
+// Imports are found with the same prefixes and syntax as they are interpreted,
+// regardless of surrounding if or foreach blocks.
+func (c *Core) importStatements(r io.Reader) (imports []importStatement, err error) {
+	var (
+		lr = newLineReader(r)
+		ln int
+	)
+	for lr.Scan() {
+		ln++
+		line := lr.Bytes()
 		prefix := c.matchedPrefixToken(line)
 		if len(prefix) == 0 {
 			continue
 		}
 
-		// Check if line contains import statement.
-		importSplit := bytes.Split(line, fmt.Appendf(nil, "%s %s", prefix, preprocessorImportName))
-		if len(importSplit) != 2 {
+		split := bytes.Split(trimLine(line, prefix), []byte{' '})
+		if string(bytes.TrimSpace(split[0])) != directiveNameImport {
 			continue
 		}
 
-		// We got the path to import.
-		// Now we need to check if the file does also import the current one.
-		foundImportPath := bytes.TrimSpace(importSplit[1])
-		importPathOfLine := filepath.Clean(string(bytes.TrimSpace(foundImportPath)))
-		cyclic := c.depsResolver.CheckForCyclicDependencies(sourcePath, importPathOfLine)
-		if cyclic {
-			return fmt.Errorf("%w: %s -> %s", errDependencyCyclic, sourcePath, importingPath)
+		imp := importStatement{
+			lineNum: ln,
+			column:  bytes.Index(line, prefix) + 1,
 		}
-
-		err = c.walkDependency(&PreprocessorDirective{
-			fileName: importingPath,
-			args:     [][]byte{[]byte(importPathOfLine)},
-		})
-		if err != nil {
-			return
+		// Import arguments follow the path.
+		if len(split) < 2 {
+			imp.err = errDependencyUnknownSyntax
+		} else {
+			imp.path = filepath.Clean(string(split[1]))
 		}
+		imports = append(imports, imp)
 	}
+	return imports, lr.Err()
+}
 
-	return
+// importDiagnostic locates err at the import statement imp inside fileName.
+func importDiagnostic(err error, fileName string, imp importStatement, stack []diagnostic.Frame) *diagnostic.Diagnostic {
+	d := locate(directiveDiagnostic(err, directiveNameImport, imp.column), fileName, imp.lineNum, 0)
+	d.ImportStack = stack
+	return d
 }
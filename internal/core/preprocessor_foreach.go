@@ -14,17 +14,17 @@ func (c *Core) foreachStart(pd *PreprocessorDirective) (err error) {
 
 	febArgs := make([]foreach.Arg, len(pd.args))
 	for i, arg := range pd.args {
-		// Trim optional chars.
-		feArg := unwrapVar(arg)
-		feArg = bytes.TrimLeft(feArg, "[")
+		// Trim optional chars before unwrapping the variable, so that list indexes like "{{hosts[0]}}" are kept.
+		feArg := bytes.TrimLeft(arg, "[")
 		feArg = bytes.TrimRight(feArg, "]")
+		feArg = c.unwrapVar(feArg)
 		if len(feArg) == 0 {
 			continue
 		}
 
 		febArgs[i] = feArg
 	}
-	c.feb.AppendState(pd.fileName, febArgs)
+	c.feb.AppendState(pd.fileName, pd.lineNum, febArgs)
 	return
 }
 
@@ -1,25 +1,67 @@
 package core
 
 import (
-	"fmt"
+	"bytes"
 	"os"
 	"path/filepath"
+
+	"github.com/xiroxasx/yatt/internal/common"
+	"github.com/xiroxasx/yatt/internal/diagnostic"
+	"github.com/xiroxasx/yatt/internal/sourcemap"
 )
 
+// importScope is the scope of a single import statement.
+type importScope struct {
+	// args are the variables passed by the import statement, e.g. "# yatt import vhost.conf name=api".
+	args []common.Variable
+	// foreachIdx and conditionIdx are the states of the importer at the import statement.
+	// The imported file must not set variables inside them.
+	foreachIdx   int
+	conditionIdx int
+}
+
 func (c *Core) importPath(pd *PreprocessorDirective) (err error) {
-	if len(pd.args) != 1 {
-		err = fmt.Errorf("unknown syntax: %s <file path>", preprocessorImportName)
+	path, args, err := c.parseImport(pd.rawArgs)
+	if err != nil {
 		return
 	}
 
+	// The argument values are resolved in the scope of the importer.
+	for j, arg := range args {
+		var value []byte
+		value, err = c.resolve(resolveArgs{
+			fileName:       pd.fileName,
+			line:           []byte(arg.Value()),
+			additionalVars: pd.additionalVars,
+		})
+		if err != nil {
+			return
+		}
+		args[j] = common.NewVar(arg.Name(), string(value))
+	}
+
 	// Open the import file.
 	// The interpret method will close it afterwards.
-	path := filepath.Clean(string(pd.args[0]))
 	importFile, err := os.Open(path)
 	if err != nil {
 		return
 	}
 
+	c.imports = append([]sourcemap.Frame{c.origin(pd.fileName, pd.lineNum, pd.additionalVars)}, c.imports...)
+	c.importScopes = append(c.importScopes, importScope{
+		args:         args,
+		foreachIdx:   c.feb.StateIndex(),
+		conditionIdx: c.cb.StateIndex(),
+	})
+	// Every import starts off without local variables, so that they are neither shared between imports of the same file nor leaked.
+	locals := c.swapLocalVars(path, nil)
+	defer func() {
+		c.imports = c.imports[1:]
+		c.importScopes = c.importScopes[:len(c.importScopes)-1]
+		c.swapLocalVars(path, locals)
+	}()
+	c.countImport()
+
 	interFile := InterpreterFile{
 		Name: path,
 		RC:   importFile,
@@ -27,7 +69,104 @@ func (c *Core) importPath(pd *PreprocessorDirective) (err error) {
 	}
 	err = c.interpret(interFile, pd.indent)
 	if err != nil {
-		return err
+		// Record the import statement which led to the failing file.
+		d := locate(err, path, 0, 0)
+		d.ImportStack = append(d.ImportStack, diagnostic.Frame{File: pd.fileName, Line: pd.lineNum})
+		return d
+	}
+	return
+}
+
+// importScope returns the scope of the innermost import.
+// ok is false if the currently interpreted file has not been imported.
+func (c *Core) importScope() (s importScope, ok bool) {
+	if len(c.importScopes) == 0 {
+		return
+	}
+	return c.importScopes[len(c.importScopes)-1], true
+}
+
+// varLookupImport looks up the import argument name, starting at the innermost import.
+func (c *Core) varLookupImport(name string) (v common.Variable) {
+	for j := len(c.importScopes) - 1; j >= 0; j-- {
+		for _, arg := range c.importScopes[j].args {
+			if arg.Name() == name {
+				return arg
+			}
+		}
+	}
+	return
+}
+
+// swapLocalVars replaces the local variables of file by vs and returns the previous ones.
+func (c *Core) swapLocalVars(file string, vs vars) (prev vars) {
+	c.varRegistryLocal.Lock()
+	defer c.varRegistryLocal.Unlock()
+
+	prev = c.varRegistryLocal.entries[file]
+	c.varRegistryLocal.entries[file] = vs
+	return
+}
+
+// parseImport parses the import statement raw, e.g. "vhost.conf name=api port=8080", into the path and its arguments.
+// Values may be quoted to contain spaces, e.g. title="Hello World", spaces inside of variables and functions are kept as well.
+func (c *Core) parseImport(raw []byte) (path string, args []common.Variable, err error) {
+	fields, err := c.splitImportArgs(raw)
+	if err != nil {
+		return
+	}
+	if len(fields) == 0 {
+		return "", nil, errDependencyUnknownSyntax
+	}
+
+	path = filepath.Clean(string(fields[0]))
+	for _, f := range fields[1:] {
+		name, value, ok := bytes.Cut(f, []byte{'='})
+		if !ok || len(name) == 0 {
+			return "", nil, errImportArgSyntax
+		}
+		args = append(args, common.NewVar(string(name), string(common.TrimQuotes(value))))
+	}
+	return
+}
+
+// splitImportArgs splits b by spaces, which are kept inside of quotes and template tokens.
+func (c *Core) splitImportArgs(b []byte) (fields [][]byte, err error) {
+	var (
+		quote byte
+		depth int
+		start = -1
+	)
+	for j := 0; j < len(b); j++ {
+		switch {
+		case quote != 0:
+			if b[j] == quote {
+				quote = 0
+			}
+		case bytes.HasPrefix(b[j:], c.templateStart):
+			depth++
+			j += len(c.templateStart) - 1
+		case depth > 0 && bytes.HasPrefix(b[j:], c.templateEnd):
+			depth--
+			j += len(c.templateEnd) - 1
+		case b[j] == '"' || b[j] == '\'':
+			quote = b[j]
+		case b[j] == ' ' && depth == 0:
+			if start >= 0 {
+				fields = append(fields, b[start:j])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = j
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, errImportArgSyntax
+	}
+	if start >= 0 {
+		fields = append(fields, b[start:])
 	}
 	return
 }
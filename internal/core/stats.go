@@ -0,0 +1,53 @@
+package core
+
+import (
+	"maps"
+	"strings"
+)
+
+// Stats describes the complexity of the last interpreted file, including its imports.
+type Stats struct {
+	// Imports is the amount of executed import directives.
+	Imports int `json:"imports"`
+	// ImportDepth is the maximum nesting of imports, 0 if nothing is imported.
+	ImportDepth int `json:"importDepth"`
+	// LoopIterations is the amount of foreach iterations, including nested ones.
+	LoopIterations int `json:"loopIterations"`
+	// FunctionCalls maps the lowercase function names to their amount of calls.
+	FunctionCalls map[string]int `json:"functionCalls,omitempty"`
+}
+
+// Stats returns the statistics of the last call to Interpret.
+func (c *Core) Stats() (s Stats) {
+	s = c.stats
+	s.FunctionCalls = maps.Clone(c.stats.FunctionCalls)
+	return
+}
+
+// Add adds the statistics of other to s.
+// The import depth is the maximum of both.
+func (s *Stats) Add(other Stats) {
+	s.Imports += other.Imports
+	s.ImportDepth = max(s.ImportDepth, other.ImportDepth)
+	s.LoopIterations += other.LoopIterations
+	for name, n := range other.FunctionCalls {
+		if s.FunctionCalls == nil {
+			s.FunctionCalls = make(map[string]int)
+		}
+		s.FunctionCalls[name] += n
+	}
+}
+
+// countImport records an import at the current import depth.
+func (c *Core) countImport() {
+	c.stats.Imports++
+	c.stats.ImportDepth = max(c.stats.ImportDepth, len(c.imports))
+}
+
+// countFunctionCall records a call of the function fncName.
+func (c *Core) countFunctionCall(fncName string) {
+	if c.stats.FunctionCalls == nil {
+		c.stats.FunctionCalls = make(map[string]int)
+	}
+	c.stats.FunctionCalls[strings.ToLower(fncName)]++
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xiroxasx/yatt/internal/common"
+)
+
+// maxSuggestions limits the amount of names suggested for an unresolved variable.
+const maxSuggestions = 3
+
+// variableNames returns the names of all variables which may be referenced inside fileName,
+// drawn from the local, override, global, foreach and condition registries and the import arguments.
+func (c *Core) variableNames(fileName string, additionalVars []common.Variable) (names []string) {
+	seen := make(map[string]struct{})
+	add := func(vs ...common.Variable) {
+		for _, v := range vs {
+			_, ok := seen[v.Name()]
+			if ok || v.Name() == "" {
+				continue
+			}
+			seen[v.Name()] = struct{}{}
+			names = append(names, v.Name())
+		}
+	}
+
+	add(additionalVars...)
+	for j := len(c.importScopes) - 1; j >= 0; j-- {
+		add(c.importScopes[j].args...)
+	}
+	c.varRegistryLocal.Lock()
+	add(c.varRegistryLocal.entries[fileName]...)
+	c.varRegistryLocal.Unlock()
+	for _, reg := range []*variableRegistry{&c.varRegistryForeach, &c.varRegistryCondition, &c.varRegistryOverride, &c.varRegistryGlobal} {
+		add(varsLookupRegistry(reg)...)
+	}
+	return
+}
+
+// didYouMean returns a hint naming the candidates which are most similar to name.
+// If none is similar enough, an empty string is returned.
+func didYouMean(name string, candidates []string) string {
+	type match struct {
+		name     string
+		distance int
+	}
+
+	// Allow roughly one typo for every three characters.
+	maxDistance := max(1, len(name)/3)
+	matches := make([]match, 0)
+	for _, cand := range candidates {
+		d := editDistance(strings.ToLower(name), strings.ToLower(cand))
+		if d <= maxDistance {
+			matches = append(matches, match{name: cand, distance: d})
+		}
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].distance != matches[j].distance {
+			return matches[i].distance < matches[j].distance
+		}
+		return matches[i].name < matches[j].name
+	})
+	if len(matches) > maxSuggestions {
+		matches = matches[:maxSuggestions]
+	}
+
+	quoted := make([]string, len(matches))
+	for i, m := range matches {
+		quoted[i] = fmt.Sprintf("%q", m.name)
+	}
+	return fmt.Sprintf(", did you mean %s?", strings.Join(quoted, " or "))
+}
+
+// editDistance returns the Damerau-Levenshtein distance (optimal string alignment) between a and b.
+// Swapped adjacent characters, a common typo, count as a single edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// Rows of the distance matrix, only the last two are required for transpositions.
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
@@ -2,10 +2,16 @@ package core
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/xiroxasx/yatt/internal/common"
+	"github.com/xiroxasx/yatt/internal/diagnostic"
+	"github.com/xiroxasx/yatt/internal/sourcemap"
 )
 
 type resolveArgs struct {
@@ -37,12 +43,14 @@ func (c *Core) searchTokensAndExecute(fileName string, line, currentLineIndent [
 			currentLineIndent,
 			additionalVars,
 		)
+		pd.column = bytes.Index(line, prefix) + 1
+		pd.rawArgs = statement[len(split[0]):]
 
 		if c.feb.IsActive() && !isForeachControlDirective(pd.name) {
 			if c.opts.PreserveIndent {
 				line = append(currentLineIndent, line...)
 			}
-			c.feb.WriteLineToBuffer(line)
+			c.feb.WriteLineToBuffer(line, lineNum)
 			return nil
 		}
 
@@ -74,7 +82,7 @@ func (c *Core) searchTokensAndExecute(fileName string, line, currentLineIndent [
 		if c.opts.PreserveIndent {
 			line = append(currentLineIndent, line...)
 		}
-		c.feb.WriteLineToBuffer(line)
+		c.feb.WriteLineToBuffer(line, lineNum)
 		return
 
 	default:
@@ -96,16 +104,45 @@ func (c *Core) searchTokensAndExecute(fileName string, line, currentLineIndent [
 	if len(bytes.TrimSpace(ret)) != 0 {
 		ret = append(currentLineIndent, ret...)
 	}
-	_, err = buf.Write(append(ret, lineEnding...))
+	_, err = buf.Write(ret)
 	if err != nil {
 		return
 	}
+	_, err = buf.Write(c.lineEnding)
+	if err != nil {
+		return
+	}
+
+	if c.sourceMap != nil {
+		// Multiline values produce more than one output line.
+		lines := bytes.Count(ret, []byte{'\n'}) + 1
+		c.sourceMap.Add(c.origin(fileName, lineNum, additionalVars), c.imports, lines)
+	}
+	return
+}
 
+// origin returns the source map frame of the template line lineNum inside fileName.
+func (c *Core) origin(fileName string, lineNum int, additionalVars []common.Variable) (f sourcemap.Frame) {
+	f = sourcemap.Frame{
+		File: fileName,
+		Line: lineNum,
+	}
+	for _, av := range additionalVars {
+		if av.Name() != "index" {
+			continue
+		}
+		idx, err := strconv.Atoi(av.Value())
+		if err == nil {
+			f.Iteration = &idx
+		}
+		break
+	}
+	f.Branch, _ = c.cb.Branch(fileName)
 	return
 }
 
 func (c *Core) resolve(rArgs resolveArgs) (_ []byte, err error) {
-	partials := bytes.Split(rArgs.line, templateStartBytes)
+	partials := bytes.Split(rArgs.line, c.templateStart)
 	if len(partials) == 1 {
 		// Nothing needs to be resolved.
 		return rArgs.line, nil
@@ -116,20 +153,27 @@ func (c *Core) resolve(rArgs resolveArgs) (_ []byte, err error) {
 	var (
 		bufIdx = -1
 		buf    = make([][]byte, 0)
+		// Offset of the current template start inside the line.
+		offset = len(partials[0])
+		// Index of the last partial which closes a token.
+		lastClosed = 0
 	)
-	for _, part := range partials[1:] {
-		tokens := bytes.Split(part, templateEndBytes)
+	for i, part := range partials[1:] {
+		column := offset + 1
+		offset += len(c.templateStart) + len(part)
+		tokens := bytes.Split(part, c.templateEnd)
 
 		if len(tokens) == 1 {
 			buf = append(buf, []byte(tokens[0]))
 			bufIdx++
 			continue
 		}
+		lastClosed = i + 1
 
 		var res []byte
 		res, err = c.resolveToken(rArgs, tokens[0])
 		if err != nil {
-			return
+			return nil, functionDiagnostic(err, tokens[0], column)
 		}
 
 		// If variables variables are used without functions,
@@ -144,7 +188,7 @@ func (c *Core) resolve(rArgs resolveArgs) (_ []byte, err error) {
 			newToken := append(buf[bufIdx-j], append(res, t...)...)
 			res, err = c.resolveToken(rArgs, newToken)
 			if err != nil {
-				return
+				return nil, functionDiagnostic(err, newToken, column)
 			}
 			rev = res
 			buf[bufIdx-j] = append(buf[bufIdx-j], res...)
@@ -153,6 +197,12 @@ func (c *Core) resolve(rArgs resolveArgs) (_ []byte, err error) {
 		ret = append(ret, append(rev, tokens[len(tokens)-1]...)...)
 	}
 
+	if c.opts.Passthrough {
+		// Template starts which are never closed belong to foreign syntax, keep them.
+		for _, part := range partials[lastClosed+1:] {
+			ret = append(append(ret, c.templateStart...), part...)
+		}
+	}
 	return ret, err
 }
 
@@ -161,8 +211,10 @@ func (c *Core) resolveToken(rArgs resolveArgs, token []byte) (ret []byte, err er
 	fnc, args := unwrapFunc(token)
 	if len(fnc) == 0 {
 		// No function found, try to lookup and replace variable.
-		ret = c.resolveVariable(rArgs.fileName, token, rArgs.additionalVars)
-		return
+		return c.resolveVariable(rArgs.fileName, token, rArgs.additionalVars)
+	}
+	if c.opts.Passthrough && !isFunction(fnc) {
+		return c.wrapToken(token), nil
 	}
 
 	// Try to resolve function.
@@ -173,22 +225,34 @@ func (c *Core) resolveToken(rArgs resolveArgs, token []byte) (ret []byte, err er
 	return
 }
 
-func (c *Core) resolveVariable(fileName string, token []byte, additionalVars []common.Variable) (ret []byte) {
+// resolveVariable returns the value of the variable named by token.
+// Unknown variables resolve to an empty value, to an error in strict mode
+// or to the unchanged token in passthrough mode.
+func (c *Core) resolveVariable(fileName string, token []byte, additionalVars []common.Variable) (ret []byte, err error) {
 	// No function found, try to lookup and replace variable.
 	tokenString := string(token)
-	v := c.varLookup(fileName, tokenString)
+	v, registry := c.varLookupWithRegistry(fileName, tokenString)
 	if v.Value() != "" {
-		return []byte(v.Value())
+		c.traceVariable(fileName, tokenString, v, registry)
+		return []byte(v.Value()), nil
 	}
 
-	for _, av := range additionalVars {
-		if av.Name() == tokenString {
-			v = av
-			break
-		}
+	// Additional variables are the ones of the current foreach iteration.
+	av, ok := lookupAdditionalVar(additionalVars, tokenString)
+	if ok {
+		v, registry = av, registryForeach
 	}
 	if v.Name() == tokenString {
-		ret = []byte(v.Value())
+		c.traceVariable(fileName, tokenString, v, registry)
+		return []byte(v.Value()), nil
+	}
+	c.traceVariable(fileName, tokenString, v, "")
+
+	if c.opts.Passthrough {
+		return c.wrapToken(token), nil
+	}
+	if c.opts.Strict {
+		err = fmt.Errorf("%w: %q%s", errUnresolvedVariable, tokenString, didYouMean(tokenString, c.variableNames(fileName, additionalVars)))
 	}
 	return
 }
@@ -218,7 +282,9 @@ func (c *Core) resolveFunction(fileName string, additionalVars []common.Variable
 	}
 
 	var mod []byte
+	c.countFunctionCall(fncName)
 	mod, err = c.executeFunction(fnc, fileName, remappedArgs, additionalVars)
+	c.traceFunction(fileName, fnc, remappedArgs, mod, err)
 	if err != nil {
 		return
 	}
@@ -226,6 +292,31 @@ func (c *Core) resolveFunction(fileName string, additionalVars []common.Variable
 	return
 }
 
+// wrapToken restores the template delimiters around token.
+func (c *Core) wrapToken(token []byte) []byte {
+	return bytes.Join([][]byte{c.templateStart, token, c.templateEnd}, nil)
+}
+
+// isFunction reports whether fnc is a function known by executeFunction.
+func isFunction(fnc parserFunc) bool {
+	_, ok := functionArity[strings.ToLower(fnc.string())]
+	return ok
+}
+
+// functionDiagnostic converts err into a diagnostic of the function called by token.
+func functionDiagnostic(err error, token []byte, column int) *diagnostic.Diagnostic {
+	var d *diagnostic.Diagnostic
+	if errors.As(err, &d) {
+		return d
+	}
+
+	fnc, _ := unwrapFunc(token)
+	d = diagnostic.New(err)
+	d.Function = fnc.string()
+	d.Column = column
+	return d
+}
+
 // unwrapFunc gets the function's name and its args from the given byte slice.
 func unwrapFunc(b []byte) (fncName parserFunc, args [][]byte) {
 	args = make([][]byte, 0)
@@ -243,12 +334,12 @@ func unwrapFunc(b []byte) (fncName parserFunc, args [][]byte) {
 	return
 }
 
-func unwrapVar(token []byte) (t []byte) {
-	tokens := bytes.Split(token, templateStartBytes)
+func (c *Core) unwrapVar(token []byte) (t []byte) {
+	tokens := bytes.Split(token, c.templateStart)
 	if len(tokens) == 1 {
 		return token
 	}
-	match := bytes.SplitN(tokens[1], templateEndBytes, 2)
+	match := bytes.SplitN(tokens[1], c.templateEnd, 2)
 	if len(match) == 2 {
 		// Token contains variable name, return it.
 		return match[0]
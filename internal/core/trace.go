@@ -0,0 +1,96 @@
+package core
+
+import (
+	"github.com/rs/zerolog"
+	"github.com/xiroxasx/yatt/internal/common"
+	"github.com/xiroxasx/yatt/internal/condition"
+)
+
+// Registries which satisfied a variable lookup, as reported by the trace log.
+const (
+	registryForeach    = "foreach"
+	registryCondition  = "condition"
+	registryLocal      = "local"
+	registryImport     = "import"
+	registryOverride   = "override"
+	registryGlobalFile = "global file"
+	registryGlobal     = "global"
+)
+
+// trace returns a trace event, which is disabled if the trace option is not set.
+func (c *Core) trace() *zerolog.Event {
+	if !c.opts.Trace {
+		return nil
+	}
+	return c.l.Trace()
+}
+
+// Iteration implements the foreach.TokenResolver interface.
+// Every iteration is counted and written to the trace log.
+func (c *Core) Iteration(fileName string, lineNum int, vars ...common.Variable) {
+	c.stats.LoopIterations++
+
+	e := c.trace()
+	if !e.Enabled() {
+		return
+	}
+
+	e = e.Str("file", fileName).Int("line", lineNum)
+	for _, v := range vars {
+		e = e.Str(v.Name(), v.Value())
+	}
+	e.Msg("foreach iteration")
+}
+
+// traceBranch writes the evaluated condition of pd and whether its branch is taken to the trace log.
+// ev is nil for else directives and for ifelse directives which are not evaluated, since a previous branch has been taken.
+func (c *Core) traceBranch(pd *PreprocessorDirective, ev *condition.Evaluation) {
+	e := c.trace()
+	if !e.Enabled() {
+		return
+	}
+
+	e = e.Str("file", pd.fileName).Int("line", pd.lineNum).Str("directive", pd.name)
+	if ev != nil {
+		e = e.Str("left", ev.Left).Bool("result", ev.Result)
+		if ev.Operator != "" {
+			e = e.Str("operator", ev.Operator).Str("right", ev.Right)
+		}
+	}
+	e.Bool("taken", c.cb.IsActive()).Msg("condition branch")
+}
+
+// traceVariable writes the lookup of the variable name to the trace log.
+// The registry is empty if the variable could not be resolved.
+func (c *Core) traceVariable(fileName, name string, v common.Variable, registry string) {
+	e := c.trace()
+	if !e.Enabled() {
+		return
+	}
+
+	e = e.Str("file", fileName).Str("variable", name)
+	if registry == "" {
+		e.Msg("variable unresolved")
+		return
+	}
+	e.Str("registry", registry).Str("value", v.Value()).Msg("variable lookup")
+}
+
+// traceFunction writes the call of fnc with its resolved args and result to the trace log.
+func (c *Core) traceFunction(fileName string, fnc parserFunc, args [][]byte, ret []byte, err error) {
+	e := c.trace()
+	if !e.Enabled() {
+		return
+	}
+
+	strArgs := make([]string, len(args))
+	for i, arg := range args {
+		strArgs[i] = string(arg)
+	}
+	e = e.Str("file", fileName).Str("function", fnc.string()).Strs("args", strArgs)
+	if err != nil {
+		e.Err(err).Msg("function call")
+		return
+	}
+	e.Str("result", string(ret)).Msg("function call")
+}
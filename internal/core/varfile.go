@@ -0,0 +1,337 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/xiroxasx/yatt/internal/common"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of var files.
+// The format is detected by the file extension or set explicitly by a "<format>:" prefix of the path, e.g. "yaml:vars.txt".
+const (
+	// VarFormatYatt contains var declarations, e.g. "# yatt var name = value".
+	VarFormatYatt = "yatt"
+	VarFormatYAML = "yaml"
+	VarFormatJSON = "json"
+	VarFormatTOML = "toml"
+	// VarFormatEnv contains "NAME=value" lines like a .env file.
+	VarFormatEnv = "env"
+)
+
+var errVarFileNoMap = errors.New("var file must contain a map at the top level")
+
+// varEntry is a key of a map inside a structured var file.
+// Maps are kept as ordered entries, so that the variables keep the order of the var file.
+type varEntry struct {
+	key string
+	// value is either a string, a []any list or a []varEntry map.
+	value any
+}
+
+// VarFilePath returns the path of the var file arg, without its optional format prefix.
+func VarFilePath(arg string) string {
+	path, _ := splitVarFile(arg)
+	return path
+}
+
+// splitVarFile splits the var file arg into its path and format.
+func splitVarFile(arg string) (path, format string) {
+	idx := strings.Index(arg, ":")
+	if idx > 0 {
+		switch arg[:idx] {
+		case VarFormatYatt, VarFormatYAML, VarFormatJSON, VarFormatTOML, VarFormatEnv:
+			return arg[idx+1:], arg[:idx]
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(arg)) {
+	case ".yaml", ".yml":
+		return arg, VarFormatYAML
+	case ".json":
+		return arg, VarFormatJSON
+	case ".toml":
+		return arg, VarFormatTOML
+	case ".env":
+		return arg, VarFormatEnv
+	}
+	return arg, VarFormatYatt
+}
+
+// parseVarFile returns the variables of the var file content cont.
+// Nested maps and lists of structured formats are kept as structured variables, see common.Structure.
+func (c *Core) parseVarFile(format string, cont []byte) (vars []common.Variable, err error) {
+	var root any
+	switch format {
+	case VarFormatYatt:
+		return c.parseYattVars(cont), nil
+	case VarFormatEnv:
+		return parseEnvVars(cont)
+	case VarFormatYAML:
+		root, err = parseYAMLVars(cont)
+	case VarFormatJSON:
+		root, err = parseJSONVars(cont)
+	case VarFormatTOML:
+		root, err = parseTOMLVars(cont)
+	}
+	if err != nil || root == nil {
+		return
+	}
+
+	entries, ok := root.([]varEntry)
+	if !ok {
+		return nil, errVarFileNoMap
+	}
+	vars = make([]common.Variable, len(entries))
+	for j, e := range entries {
+		vars[j] = structuredVar(e.key, e.value)
+	}
+	return
+}
+
+// parseYattVars returns the variables of all var declarations inside cont.
+func (c *Core) parseYattVars(cont []byte) (vars []common.Variable) {
+	// Var files may use a different line ending than the templates,
+	// trailing carriage returns are trimmed along with the other whitespace.
+	lines := bytes.Split(cont, []byte{'\n'})
+	for _, l := range lines {
+		split := bytes.Split(c.cutPrefix(l), []byte{' '})
+		if len(split) < 3 || string(split[0]) != directiveNameVariable {
+			continue
+		}
+
+		// Skip the var declaration keyword.
+		vars = append(vars, common.VarFromArg(bytes.Join(split[1:], []byte(" "))))
+	}
+	return
+}
+
+// structuredVar converts value into a variable, maps and lists are converted into structured variables.
+func structuredVar(name string, value any) common.Variable {
+	switch v := value.(type) {
+	case []varEntry:
+		children := make([]common.Variable, len(v))
+		for j, e := range v {
+			children[j] = structuredVar(e.key, e.value)
+		}
+		return common.NewMap(name, children...)
+	case []any:
+		items := make([]common.Variable, len(v))
+		for j, item := range v {
+			items[j] = structuredVar("", item)
+		}
+		return common.NewList(name, items...)
+	case string:
+		return common.NewVar(name, v)
+	}
+	return common.NewVar(name, "")
+}
+
+// parseEnvVars returns the variables of the "NAME=value" lines inside cont.
+// Empty lines and comments are skipped, an "export " prefix is ignored.
+// Double quoted values may contain escape sequences like "\n".
+func parseEnvVars(cont []byte) (vars []common.Variable, err error) {
+	lr := newLineReader(bytes.NewReader(cont))
+	lineNum := 0
+	for lr.Scan() {
+		lineNum++
+		line := strings.TrimSpace(string(lr.Bytes()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("line %d: expected NAME=value", lineNum)
+		}
+
+		value = strings.TrimSpace(value)
+		switch {
+		case len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"':
+			value, err = strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNum, err)
+			}
+		case len(value) > 1 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		default:
+			// Strip trailing comments of unquoted values.
+			idx := strings.Index(value, " #")
+			if idx >= 0 {
+				value = strings.TrimSpace(value[:idx])
+			}
+		}
+		vars = append(vars, common.NewVar(name, value))
+	}
+	return vars, lr.Err()
+}
+
+// parseYAMLVars returns the ordered value tree of the YAML document cont.
+func parseYAMLVars(cont []byte) (root any, err error) {
+	var n yaml.Node
+	err = yaml.Unmarshal(cont, &n)
+	if err != nil {
+		return
+	}
+	return yamlValue(&n), nil
+}
+
+func yamlValue(n *yaml.Node) any {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil
+		}
+		return yamlValue(n.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.SequenceNode:
+		list := make([]any, len(n.Content))
+		for j, item := range n.Content {
+			list[j] = yamlValue(item)
+		}
+		return list
+	case yaml.MappingNode:
+		entries := make([]varEntry, 0, len(n.Content)/2)
+		for j := 0; j+1 < len(n.Content); j += 2 {
+			entries = append(entries, varEntry{key: n.Content[j].Value, value: yamlValue(n.Content[j+1])})
+		}
+		return entries
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			return ""
+		}
+		return n.Value
+	}
+	return nil
+}
+
+// parseJSONVars returns the ordered value tree of the JSON document cont.
+func parseJSONVars(cont []byte) (root any, err error) {
+	dec := json.NewDecoder(bytes.NewReader(cont))
+	dec.UseNumber()
+	root, err = jsonValue(dec)
+	if errors.Is(err, io.EOF) {
+		// Empty files contain no variables.
+		return nil, nil
+	}
+	return
+}
+
+func jsonValue(dec *json.Decoder) (v any, err error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			list := make([]any, 0)
+			for dec.More() {
+				var item any
+				item, err = jsonValue(dec)
+				if err != nil {
+					return
+				}
+				list = append(list, item)
+			}
+			_, err = dec.Token()
+			return list, err
+		}
+
+		entries := make([]varEntry, 0)
+		for dec.More() {
+			tok, err = dec.Token()
+			if err != nil {
+				return
+			}
+			var value any
+			value, err = jsonValue(dec)
+			if err != nil {
+				return
+			}
+			entries = append(entries, varEntry{key: tok.(string), value: value})
+		}
+		_, err = dec.Token()
+		return entries, err
+	case json.Number:
+		return t.String(), nil
+	case string:
+		return t, nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	}
+	// null
+	return "", nil
+}
+
+// parseTOMLVars returns the ordered value tree of the TOML document cont.
+func parseTOMLVars(cont []byte) (root any, err error) {
+	m := make(map[string]any)
+	md, err := toml.Decode(string(cont), &m)
+	if err != nil {
+		return
+	}
+
+	// Keep the order of the document, the decoded maps are unordered.
+	order := make(map[string]int)
+	for j, k := range md.Keys() {
+		key := k.String()
+		_, ok := order[key]
+		if !ok {
+			order[key] = j
+		}
+	}
+	return tomlValue(m, "", order), nil
+}
+
+func tomlValue(v any, path string, order map[string]int) any {
+	switch t := v.(type) {
+	case map[string]any:
+		entries := make([]varEntry, 0, len(t))
+		for k, value := range t {
+			entries = append(entries, varEntry{key: k, value: tomlValue(value, joinTOMLKey(path, k), order)})
+		}
+		sort.Slice(entries, func(a, b int) bool {
+			return order[joinTOMLKey(path, entries[a].key)] < order[joinTOMLKey(path, entries[b].key)]
+		})
+		return entries
+	case []map[string]any:
+		list := make([]any, len(t))
+		for j, item := range t {
+			list[j] = tomlValue(item, path, order)
+		}
+		return list
+	case []any:
+		list := make([]any, len(t))
+		for j, item := range t {
+			list[j] = tomlValue(item, path, order)
+		}
+		return list
+	case time.Time:
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// joinTOMLKey joins the key k to path like toml.Key.String.
+func joinTOMLKey(path, k string) string {
+	key := toml.Key{k}.String()
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -1,7 +1,7 @@
 package core
 
 import (
-	"bytes"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -10,7 +10,8 @@ import (
 )
 
 const (
-	variableRegistryGlobalRegister = "global"
+	variableRegistryGlobalRegister   = "global"
+	variableRegistryOverrideRegister = "override"
 )
 
 type variable struct {
@@ -41,26 +42,49 @@ func (c *Core) VarLookupRecursive(fileName, name string, untilForeachIdx int) (_
 // Variable setter.
 //
 
-func (c *Core) InitGlobalVariablesByFiles(varFileNames ...string) {
+// InitGlobalVariablesByFiles reads the global variables of the given var files, see VarFormatYatt and the other formats.
+// The variables of every file are registered under its path, without the optional format prefix.
+func (c *Core) InitGlobalVariablesByFiles(varFileNames ...string) (err error) {
 	// Check if the global var files exist and read it into the memory.
-	for _, vf := range varFileNames {
-		cont, err := os.ReadFile(vf)
+	for _, arg := range varFileNames {
+		vf, format := splitVarFile(arg)
+		var cont []byte
+		cont, err = os.ReadFile(vf)
 		if err != nil {
-			c.l.Fatal().Err(err).Str("path", vf).Msg("unable to read variable file")
+			return fmt.Errorf("unable to read variable file: %v", err)
 		}
 
-		lines := bytes.Split(cont, lineEnding)
-		for _, l := range lines {
-			split := bytes.Split(c.cutPrefix(l), []byte{' '})
-			if len(split) < 3 || string(split[0]) != directiveNameVariable {
-				continue
-			}
-
-			// Skip the var declaration keyword.
-			v := common.VarFromArg(bytes.Join(split[1:], []byte(" ")))
+		var vars []common.Variable
+		vars, err = c.parseVarFile(format, cont)
+		if err != nil {
+			return fmt.Errorf("unable to parse variable file %s: %v", vf, err)
+		}
+		for _, v := range vars {
 			c.setGlobalVarWithReg(vf, v)
 		}
 	}
+	return
+}
+
+// SetGlobalVariable sets a global variable which is not bound to any var file.
+func (c *Core) SetGlobalVariable(name, value string) (err error) {
+	if name == "" {
+		return errEmptyVariableParameter
+	}
+
+	c.setGlobalVar(common.NewVar(name, value))
+	return
+}
+
+// SetOverrideVariable sets a global variable which takes precedence over the variables of all var files.
+// Local variables and the variables of foreach loops and conditions still shadow it.
+func (c *Core) SetOverrideVariable(name, value string) (err error) {
+	if name == "" {
+		return errEmptyVariableParameter
+	}
+
+	setRegistryVar(&c.varRegistryOverride, variableRegistryOverrideRegister, common.NewVar(name, value))
+	return
 }
 
 func (c *Core) setConditionVar(register string, newVar common.Variable) {
@@ -87,7 +111,11 @@ func setRegistryVar(reg *variableRegistry, register string, newVar common.Variab
 	reg.Lock()
 	defer reg.Unlock()
 
-	for i, v := range reg.entries[register] {
+	entries, ok := reg.entries[register]
+	if !ok {
+		reg.order = append(reg.order, register)
+	}
+	for i, v := range entries {
 		if newVar.Name() == v.Name() {
 			// Update existing variable.
 			reg.entries[register][i] = newVar
@@ -103,36 +131,86 @@ func setRegistryVar(reg *variableRegistry, register string, newVar common.Variab
 //
 
 func (c *Core) varLookup(file, name string) (v common.Variable) {
+	v, _ = c.varLookupWithRegistry(file, name)
+	return
+}
+
+// varLookupWithRegistry looks up the variable name and returns the name of the registry which contains it.
+// name may also be the path of a value nested inside a structured variable, e.g. "db.host" or "hosts[2]".
+// If the variable is not found, the registry is empty.
+func (c *Core) varLookupWithRegistry(file, name string) (v common.Variable, registry string) {
+	v, registry = c.varLookupName(file, name)
+	if registry != "" {
+		return
+	}
+
+	root, path := common.SplitPath(name)
+	if len(path) == 0 {
+		return
+	}
+	rv, registry := c.varLookupName(file, root)
+	if registry == "" {
+		return
+	}
+	nested, ok := common.Lookup(rv, path)
+	if !ok {
+		return variable{}, ""
+	}
+	// Keep the requested name, so that nested values are not mistaken for variables named like their key.
+	return common.Rename(nested, name), registry
+}
+
+// varLookupName looks up the variable name in all registries, starting at the innermost scope.
+func (c *Core) varLookupName(file, name string) (v common.Variable, registry string) {
 	if c.feb.StateIndex() > -1 {
 		v = c.varLookupForeach(c.feb.StateIndex(), name)
 		if v != nil {
-			return
+			return v, registryForeach
 		}
 	}
 	if c.cb.StateIndex() > -1 {
 		v = c.varLookupCondition(c.cb.StateIndex(), name)
 		if v != nil {
-			return
+			return v, registryCondition
 		}
 	}
 
 	v = c.varLookupLocal(file, name)
 	if v.Name() != "" {
-		return
+		return v, registryLocal
+	}
+
+	v = c.varLookupImport(name)
+	if v != nil {
+		return v, registryImport
+	}
+
+	v = c.varLookupOverride(name)
+	if v.Name() != "" {
+		return v, registryOverride
 	}
 
 	v = c.varLookupGlobalWithRegister(file, name)
 	if v.Name() != "" {
-		return
+		return v, registryGlobalFile
 	}
 
 	v = c.varLookupGlobal(name)
+	if v.Name() != "" {
+		return v, registryGlobal
+	}
 	return
 }
 
+// varLookupGlobal looks up the global variable name.
+// If multiple var files declare it, the variable of the last loaded file is returned.
 func (c *Core) varLookupGlobal(name string) (v common.Variable) {
-	for k := range c.varRegistryGlobal.entries {
-		for _, v := range c.varRegistryGlobal.entries[k] {
+	c.varRegistryGlobal.Lock()
+	defer c.varRegistryGlobal.Unlock()
+
+	order := c.varRegistryGlobal.order
+	for j := len(order) - 1; j >= 0; j-- {
+		for _, v := range c.varRegistryGlobal.entries[order[j]] {
 			if v.Name() == name {
 				return v
 			}
@@ -142,6 +220,10 @@ func (c *Core) varLookupGlobal(name string) (v common.Variable) {
 	return variable{}
 }
 
+func (c *Core) varLookupOverride(name string) (v common.Variable) {
+	return varLookupRegistry(&c.varRegistryOverride, variableRegistryOverrideRegister, name)
+}
+
 func (c *Core) varLookupGlobalWithRegister(register, name string) (v common.Variable) {
 	return varLookupRegistry(&c.varRegistryGlobal, register, name)
 }
@@ -179,7 +261,29 @@ func (c *Core) varLookupForeach(stateIdx int, name string) (_ common.Variable) {
 	return c.varLookupContained(stateIdx, name, &c.varRegistryForeach, idxs)
 }
 
-func (c *Core) varLookupRecursive(fileName, name string, foreachStateIdx int) (_ []common.Variable) {
+func (c *Core) varLookupRecursive(fileName, name string, foreachStateIdx int) (vs []common.Variable) {
+	vs = c.varLookupRecursiveName(fileName, name, foreachStateIdx)
+	if len(vs) > 0 {
+		return
+	}
+
+	// Try the path of a value nested inside a structured variable.
+	root, path := common.SplitPath(name)
+	if len(path) == 0 {
+		return
+	}
+	roots := c.varLookupRecursiveName(fileName, root, foreachStateIdx)
+	if len(roots) != 1 {
+		return
+	}
+	nested, ok := common.Lookup(roots[0], path)
+	if !ok {
+		return
+	}
+	return []common.Variable{common.Rename(nested, name)}
+}
+
+func (c *Core) varLookupRecursiveName(fileName, name string, foreachStateIdx int) (_ []common.Variable) {
 	v := c.varLookupForeach(foreachStateIdx, name)
 	if v != nil {
 		return []common.Variable{v}
@@ -191,6 +295,16 @@ func (c *Core) varLookupRecursive(fileName, name string, foreachStateIdx int) (_
 		return []common.Variable{vs}
 	}
 
+	vs = c.varLookupImport(name)
+	if vs != nil {
+		return []common.Variable{vs}
+	}
+
+	vs = c.varLookupOverride(name)
+	if vs.Name() != "" {
+		return []common.Variable{vs}
+	}
+
 	// Try to find it against a global var file name.
 	vArgs := strings.Split(name, variableGlobalKeyFile)
 	if len(vArgs) > 1 {
@@ -202,24 +316,53 @@ func (c *Core) varLookupRecursive(fileName, name string, foreachStateIdx int) (_
 	if name == variableGlobalKey {
 		return c.varsLookupGlobal()
 	}
-	gVars := c.VarsLookupGlobal()
-	for _, v := range gVars {
-		if v.Name() == name {
-			return []common.Variable{v}
-		}
+	vs = c.varLookupGlobal(name)
+	if vs.Name() != "" {
+		return []common.Variable{vs}
 	}
 
 	return
 }
 
+// varsLookupGlobalFile returns the variables of the var file register.
+// Values which are overridden on the command line are replaced.
 func (c *Core) varsLookupGlobalFile(register string) (v []common.Variable) {
-	return c.varRegistryGlobal.entries[register]
+	c.varRegistryGlobal.Lock()
+	v = append(v, c.varRegistryGlobal.entries[register]...)
+	c.varRegistryGlobal.Unlock()
+
+	for j, gv := range v {
+		ov := c.varLookupOverride(gv.Name())
+		if ov.Name() != "" {
+			v[j] = ov
+		}
+	}
+	return
 }
 
+// varsLookupGlobal returns all global variables in the order of their var files, followed by the overrides which no var file declares.
+// Values which are overridden on the command line are replaced.
 func (c *Core) varsLookupGlobal() (v []common.Variable) {
+	c.varRegistryGlobal.Lock()
 	v = make([]common.Variable, 0)
-	for _, vars := range c.varRegistryGlobal.entries {
-		v = append(v, vars...)
+	for _, reg := range c.varRegistryGlobal.order {
+		v = append(v, c.varRegistryGlobal.entries[reg]...)
+	}
+	c.varRegistryGlobal.Unlock()
+
+	overridden := make(map[string]struct{})
+	for j, gv := range v {
+		ov := c.varLookupOverride(gv.Name())
+		if ov.Name() != "" {
+			v[j] = ov
+			overridden[ov.Name()] = struct{}{}
+		}
+	}
+	for _, ov := range varsLookupRegistry(&c.varRegistryOverride) {
+		_, ok := overridden[ov.Name()]
+		if !ok {
+			v = append(v, ov)
+		}
 	}
 	return
 }
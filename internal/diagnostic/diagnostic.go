@@ -0,0 +1,128 @@
+// Package diagnostic describes errors which are located inside templates.
+package diagnostic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Severity string
+
+const SeverityError Severity = "error"
+
+// Formats of the CLI's -diagnostics option.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var ErrInvalidFormat = fmt.Errorf("diagnostics format must either be %s or %s", FormatText, FormatJSON)
+
+// Frame is the location of an import statement.
+type Frame struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+}
+
+// Diagnostic is an error located inside a template.
+// Lines and columns start at 1, 0 means unknown.
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Column   int      `json:"column,omitempty"`
+	// Directive is the name of the preprocessor directive, e.g. "import".
+	Directive string `json:"directive,omitempty"`
+	// Function is the name of the template function, e.g. "upper".
+	Function string `json:"function,omitempty"`
+	Message  string `json:"message"`
+	// ImportStack contains the import statements which led to File, innermost first.
+	ImportStack []Frame `json:"importStack,omitempty"`
+
+	err error
+}
+
+// New creates an error diagnostic caused by err.
+func New(err error) *Diagnostic {
+	return &Diagnostic{
+		Severity: SeverityError,
+		Message:  err.Error(),
+		err:      err,
+	}
+}
+
+func (d *Diagnostic) Error() string {
+	sb := strings.Builder{}
+	if d.File != "" {
+		sb.WriteString(d.File)
+		if d.Line > 0 {
+			fmt.Fprintf(&sb, ":%d", d.Line)
+		}
+		if d.Column > 0 {
+			fmt.Fprintf(&sb, ":%d", d.Column)
+		}
+		sb.WriteString(": ")
+	}
+	if d.Directive != "" {
+		fmt.Fprintf(&sb, "%s: ", d.Directive)
+	}
+	if d.Function != "" {
+		fmt.Fprintf(&sb, "%s(): ", d.Function)
+	}
+	sb.WriteString(d.Message)
+	for _, f := range d.ImportStack {
+		fmt.Fprintf(&sb, "\n\timported by %s:%d", f.File, f.Line)
+	}
+	return sb.String()
+}
+
+func (d *Diagnostic) Unwrap() error {
+	return d.err
+}
+
+// Collect returns all diagnostics contained in err.
+// Errors joined by errors.Join are collected individually,
+// errors without a diagnostic are converted into one without location.
+func Collect(err error) (ds []*Diagnostic) {
+	if err == nil {
+		return
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if ok {
+		for _, e := range joined.Unwrap() {
+			ds = append(ds, Collect(e)...)
+		}
+		return
+	}
+
+	var d *Diagnostic
+	if errors.As(err, &d) {
+		return []*Diagnostic{d}
+	}
+	return []*Diagnostic{New(err)}
+}
+
+// WriteJSON writes all diagnostics of err to w, one JSON object per line.
+func WriteJSON(w io.Writer, err error) error {
+	return Write(w, FormatJSON, Collect(err)...)
+}
+
+// Write writes the diagnostics to w in the given format, one diagnostic per line.
+func Write(w io.Writer, format string, ds ...*Diagnostic) (err error) {
+	enc := json.NewEncoder(w)
+	for _, d := range ds {
+		if format == FormatJSON {
+			err = enc.Encode(d)
+		} else {
+			_, err = fmt.Fprintf(w, "%s: %s\n", d.Severity, d.Error())
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package diagnostic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	r "github.com/stretchr/testify/require"
+)
+
+func TestError(t *testing.T) {
+	t.Parallel()
+
+	cause := errors.New("unknown function")
+	d := New(cause)
+	r.Exactly(t, "unknown function", d.Error())
+	r.ErrorIs(t, d, cause)
+
+	d.File = "partial.txt"
+	d.Line = 3
+	d.Column = 7
+	d.Function = "nosuch"
+	d.ImportStack = []Frame{{File: "root.txt", Line: 12}}
+	r.Exactly(t, "partial.txt:3:7: nosuch(): unknown function\n\timported by root.txt:12", d.Error())
+}
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	located := New(errors.New("located"))
+	located.File = "a.txt"
+	located.Line = 1
+	plain := errors.New("plain")
+
+	err := errors.Join(
+		fmt.Errorf("a.txt: %w", located),
+		fmt.Errorf("b.txt: %w", plain),
+	)
+	ds := Collect(err)
+	r.Len(t, ds, 2)
+	r.Same(t, located, ds[0])
+	r.Exactly(t, "b.txt: plain", ds[1].Message)
+	r.Exactly(t, SeverityError, ds[1].Severity)
+	r.Empty(t, Collect(nil))
+}
+
+func TestWriteJSON(t *testing.T) {
+	t.Parallel()
+
+	d := New(errors.New("unknown preprocessor directive"))
+	d.File = "bad.txt"
+	d.Line = 2
+	d.Column = 1
+	d.Directive = "foo"
+	d.ImportStack = []Frame{{File: "root.txt", Line: 4}}
+
+	buf := &bytes.Buffer{}
+	r.NoError(t, WriteJSON(buf, errors.Join(d, errors.New("other"))))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	r.Len(t, lines, 2)
+
+	decoded := Diagnostic{}
+	r.NoError(t, json.Unmarshal([]byte(lines[0]), &decoded))
+	r.Exactly(t, SeverityError, decoded.Severity)
+	r.Exactly(t, "bad.txt", decoded.File)
+	r.Exactly(t, 2, decoded.Line)
+	r.Exactly(t, 1, decoded.Column)
+	r.Exactly(t, "foo", decoded.Directive)
+	r.Exactly(t, "unknown preprocessor directive", decoded.Message)
+	r.Exactly(t, []Frame{{File: "root.txt", Line: 4}}, decoded.ImportStack)
+	r.JSONEq(t, `{"severity":"error","message":"other"}`, lines[1])
+}
@@ -22,6 +22,10 @@ func (b *Buffer) Evaluate(lineNum int, dst io.Writer, tr TokenResolver) (err err
 	defer func() {
 		b.stateMx.Lock()
 		b.evalStateIdx = -1
+		// Start off empty for the next loop of the file.
+		b.preEvalIdx = -1
+		b.states = make([]state, 0)
+		b.linesBuffered = 0
 		b.stateMx.Unlock()
 	}()
 
@@ -32,10 +36,13 @@ func (b *Buffer) eval(stateIdx int, lineNum int, tr TokenResolver, dst io.Writer
 	vars, rangeNum := b.loopEnumerator(b.states[stateIdx].fileName, tr, stateIdx)
 	if rangeNum > -1 {
 		for i := 0; i < rangeNum; i++ {
-			// Evaluate each state (may be nested) accordingly.
-			err = b.evalLines(stateIdx, lineNum, tr, dst, []common.Variable{
+			iterVars := []common.Variable{
 				common.NewVar("index", strconv.Itoa(i)),
-			}...)
+			}
+			tr.Iteration(b.states[stateIdx].fileName, b.states[stateIdx].lineNum, iterVars...)
+
+			// Evaluate each state (may be nested) accordingly.
+			err = b.evalLines(stateIdx, lineNum, tr, dst, iterVars...)
 			if err != nil {
 				return
 			}
@@ -47,11 +54,17 @@ func (b *Buffer) eval(stateIdx int, lineNum int, tr TokenResolver, dst io.Writer
 	}
 
 	for i, v := range vars {
-		// Evaluate each state (may be nested) accordingly.
-		err = b.evalLines(stateIdx, lineNum, tr, dst, []common.Variable{
+		// The key is the name of the variable, the key of a map entry or the index of a list item.
+		// Structured values are kept, so that their nested values can be accessed, e.g. "value.host".
+		iterVars := []common.Variable{
 			common.NewVar("index", strconv.Itoa(i)),
-			common.NewVar("value", v.Value()),
-		}...)
+			common.NewVar("key", v.Name()),
+			common.Rename(v, "value"),
+		}
+		tr.Iteration(b.states[stateIdx].fileName, b.states[stateIdx].lineNum, iterVars...)
+
+		// Evaluate each state (may be nested) accordingly.
+		err = b.evalLines(stateIdx, lineNum, tr, dst, iterVars...)
 		if err != nil {
 			return
 		}
@@ -65,7 +78,7 @@ func (b *Buffer) eval(stateIdx int, lineNum int, tr TokenResolver, dst io.Writer
 func (b *Buffer) evalLines(stateIdx int, lineNum int, tr TokenResolver, dst io.Writer, vars ...common.Variable) (err error) {
 	defer func() {
 		if err != nil {
-			err = fmt.Errorf("foreach evaluation: %v (line %d)", err, lineNum)
+			err = fmt.Errorf("foreach evaluation: %w (line %d)", err, lineNum)
 		}
 	}()
 
@@ -99,7 +112,8 @@ line:
 		}
 
 		line := bytes.TrimSuffix(state.lines[bufLn], b.lineEnding)
-		err = tr.EvaluateLine(state.fileName, line, nil, dst, ln, append(vars, common.NewVar("line", strconv.Itoa(ln)))...)
+		// Evaluate the line with its template line, the line variable contains the buffer line.
+		err = tr.EvaluateLine(state.fileName, line, nil, dst, state.lineNums[bufLn], append(vars, common.NewVar("line", strconv.Itoa(ln)))...)
 		if err != nil {
 			return
 		}
@@ -127,6 +141,13 @@ func (b *Buffer) loopEnumerator(fileName string, tr TokenResolver, stateIdx int)
 	for _, arg := range state.args {
 		argStr := string(arg)
 
+		// Maps and lists are iterated by their nested values.
+		s, ok := lookupStructure(fileName, tr, argStr, stateIdx)
+		if ok {
+			variables = append(variables, s.Children()...)
+			continue
+		}
+
 		if argsLen == 1 {
 			// Looks like the user wants to range over the amount specified in the arg.
 			rangeNum = 0
@@ -156,3 +177,13 @@ func (b *Buffer) loopEnumerator(fileName string, tr TokenResolver, stateIdx int)
 
 	return variables, -1
 }
+
+// lookupStructure returns the map or list variable name.
+func lookupStructure(fileName string, tr TokenResolver, name string, stateIdx int) (s common.Structure, ok bool) {
+	vars := tr.VarLookupRecursive(fileName, name, stateIdx)
+	if len(vars) != 1 {
+		return
+	}
+	s, ok = vars[0].(common.Structure)
+	return
+}
@@ -12,6 +12,8 @@ import (
 type TokenResolver interface {
 	Resolve(fileName string, l []byte, vars ...common.Variable) (ret []byte, err error)
 	EvaluateLine(fileName string, line, currentLineIndent []byte, dst io.Writer, lineNum int, vars ...common.Variable) error
+	// Iteration is called at the start of every loop iteration with its index and value variables.
+	Iteration(fileName string, lineNum int, vars ...common.Variable)
 	VarLookupRecursive(fileName, name string, untilForeachIdx int) (_ []common.Variable)
 }
 
@@ -25,11 +27,15 @@ type Buffer struct {
 }
 
 type state struct {
-	fileName         string
-	args             []Arg
-	jumps            []jump
-	closed           bool
-	lines            [][]byte
+	fileName string
+	// lineNum is the line of the foreach directive.
+	lineNum int
+	args    []Arg
+	jumps   []jump
+	closed  bool
+	lines   [][]byte
+	// lineNums contains the template line of every buffered line.
+	lineNums         []int
 	previousStateIdx int
 }
 
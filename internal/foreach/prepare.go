@@ -1,6 +1,6 @@
 package foreach
 
-func (b *Buffer) AppendState(fileName string, args []Arg) {
+func (b *Buffer) AppendState(fileName string, lineNum int, args []Arg) {
 	b.stateMx.Lock()
 	defer b.stateMx.Unlock()
 
@@ -21,6 +21,7 @@ func (b *Buffer) AppendState(fileName string, args []Arg) {
 	b.preEvalIdx = idx
 	b.states = append(b.states, state{
 		fileName: fileName,
+		lineNum:  lineNum,
 		args:     args,
 		jumps:    make([]jump, 0),
 		lines:    make([][]byte, 0),
@@ -46,11 +47,13 @@ func (b *Buffer) MoveToPreviousState() (idx int) {
 	return
 }
 
-func (b *Buffer) WriteLineToBuffer(v []byte) {
+// WriteLineToBuffer buffers the line v, which is located at lineNum inside the template.
+func (b *Buffer) WriteLineToBuffer(v []byte, lineNum int) {
 	// If the last state is closed, we need to write to the latest state.
 	idx := b.preEvalIdx
 	v = append(v, b.lineEnding...)
 	b.states[idx].lines = append(b.states[idx].lines, v)
+	b.states[idx].lineNums = append(b.states[idx].lineNums, lineNum)
 
 	b.linesBuffered++
 }
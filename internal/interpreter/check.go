@@ -0,0 +1,110 @@
+package interpreter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+var errDrift = errors.New("outputs do not match their templates")
+
+// checkReport collects the results of the check mode.
+type checkReport struct {
+	mx      *sync.Mutex
+	checked map[string]struct{}
+	drifted []string
+	missing []string
+	extra   []string
+}
+
+func newCheckReport() checkReport {
+	return checkReport{
+		mx:      &sync.Mutex{},
+		checked: make(map[string]struct{}),
+	}
+}
+
+func (r *checkReport) err() error {
+	if len(r.drifted) == 0 && len(r.missing) == 0 && len(r.extra) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: drifted=%d, missing=%d, extra=%d", errDrift, len(r.drifted), len(r.missing), len(r.extra))
+}
+
+// checkOutput compares the content written by write against the existing file at outPath.
+// Mismatches are printed as unified diff.
+func (i *Interpreter) checkOutput(outPath string, write func(out io.Writer) error) (err error) {
+	rendered := &bytes.Buffer{}
+	err = write(rendered)
+	if err != nil {
+		return
+	}
+
+	// Outputs may be checked concurrently, keep the report and diffs consistent.
+	i.report.mx.Lock()
+	defer i.report.mx.Unlock()
+
+	i.report.checked[outPath] = struct{}{}
+
+	existing, err := os.ReadFile(outPath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+
+		i.report.missing = append(i.report.missing, outPath)
+		i.l.Warn().Str("file", outPath).Msg("output is missing")
+		return nil
+	}
+
+	if bytes.Equal(existing, rendered.Bytes()) {
+		return
+	}
+
+	i.report.drifted = append(i.report.drifted, outPath)
+	i.l.Warn().Str("file", outPath).Msg("output drifted")
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(existing)),
+		B:        difflib.SplitLines(rendered.String()),
+		FromFile: outPath,
+		ToFile:   outPath + " (rendered)",
+		Context:  3,
+	})
+	if err != nil {
+		return
+	}
+	_, err = io.WriteString(i.stdout, diff)
+	return
+}
+
+// checkExtraOutputs reports all files inside outPath which are not produced by any template.
+func (i *Interpreter) checkExtraOutputs(outPath string) (err error) {
+	err = filepath.WalkDir(outPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || isSidecar(entry.Name()) {
+			return nil
+		}
+
+		_, ok := i.report.checked[path]
+		if ok {
+			return nil
+		}
+		i.report.extra = append(i.report.extra, path)
+		i.l.Warn().Str("file", path).Msg("output has no template")
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		// Every output is missing, which has already been reported.
+		err = nil
+	}
+	return
+}
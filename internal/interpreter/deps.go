@@ -0,0 +1,258 @@
+package interpreter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Formats of the dependency graph printed by Deps.
+const (
+	DepsFormatTree = "tree"
+	DepsFormatDOT  = "dot"
+	DepsFormatJSON = "json"
+)
+
+var (
+	ErrInvalidDepsFormat = fmt.Errorf("deps format must be one of %s, %s or %s", DepsFormatTree, DepsFormatDOT, DepsFormatJSON)
+	errNotInGraph        = errors.New("file is neither a template nor imported by any template")
+)
+
+// depsGraph is the import graph of all templates of the input path.
+type depsGraph struct {
+	// Roots are the files the graph is printed from,
+	// either the templates or the partial whose dependents are listed.
+	Roots []string `json:"roots"`
+	// Imports maps every file to the files it imports directly.
+	Imports map[string][]string `json:"imports"`
+	// ImportedBy maps every file to the files which import it directly.
+	ImportedBy map[string][]string `json:"importedBy"`
+	// Outputs maps the templates to their output paths, if an output path is configured.
+	Outputs map[string]string `json:"outputs,omitempty"`
+
+	reverse bool
+}
+
+// Deps writes the import graph of all templates of the input path to stdout.
+// If reverse is set, only the files which import reverse, directly or transitively, are written.
+// This includes the affected templates and their outputs.
+func (i *Interpreter) Deps(format, reverse string) (err error) {
+	switch format {
+	case DepsFormatTree, DepsFormatDOT, DepsFormatJSON:
+	default:
+		return ErrInvalidDepsFormat
+	}
+
+	g, err := i.depsGraph()
+	if err != nil {
+		return
+	}
+	if reverse != "" {
+		g, err = g.dependents(filepath.Clean(reverse))
+		if err != nil {
+			return fmt.Errorf("%s: %w", reverse, err)
+		}
+	}
+
+	switch format {
+	case DepsFormatDOT:
+		return g.writeDOT(i.stdout)
+	case DepsFormatJSON:
+		enc := json.NewEncoder(i.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(g)
+	}
+	return g.writeTree(i.stdout)
+}
+
+// depsGraph records the imports of all templates of the input path.
+func (i *Interpreter) depsGraph() (g depsGraph, err error) {
+	g = depsGraph{
+		Imports:    make(map[string][]string),
+		ImportedBy: make(map[string][]string),
+		Outputs:    make(map[string]string),
+	}
+
+	g.Roots, err = i.lintInputs()
+	if err != nil {
+		return
+	}
+	for _, root := range g.Roots {
+		if root == stdioPath {
+			err = i.core.CheckCyclicDependencies(stdioPath, i.stdin)
+		} else {
+			err = i.core.ImportPathCheckCyclicDependencies(root)
+		}
+		if err != nil {
+			return g, fmt.Errorf("dependency check: %w", err)
+		}
+
+		out := i.outputPath(root)
+		if out != "" {
+			g.Outputs[root] = out
+		}
+	}
+
+	// Record every file reachable from the templates.
+	queue := append([]string(nil), g.Roots...)
+	seen := make(map[string]struct{})
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if _, ok := seen[cur]; ok {
+			continue
+		}
+		seen[cur] = struct{}{}
+
+		imports := i.core.Imports(cur)
+		g.Imports[cur] = append([]string{}, imports...)
+		for _, imp := range imports {
+			g.ImportedBy[imp] = append(g.ImportedBy[imp], cur)
+			queue = append(queue, imp)
+		}
+	}
+	for _, importers := range g.ImportedBy {
+		sort.Strings(importers)
+	}
+	return
+}
+
+// outputPath returns the output of the template at inPath, or an empty string if no output path is configured.
+func (i *Interpreter) outputPath(inPath string) string {
+	outPath := i.opts.OutPath
+	if outPath == "" || inPath == stdioPath {
+		return ""
+	}
+	outPath = filepath.Clean(outPath)
+	inRoot := filepath.Clean(i.opts.InPath)
+	stat, err := os.Stat(inRoot)
+	if err != nil || !stat.IsDir() {
+		return outPath
+	}
+	return strings.ReplaceAll(inPath, inRoot, outPath)
+}
+
+// dependents returns the subgraph of all files which import partial, directly or transitively.
+func (g depsGraph) dependents(partial string) (sub depsGraph, err error) {
+	// Every file reachable from the templates is recorded.
+	_, ok := g.Imports[partial]
+	if !ok {
+		return sub, errNotInGraph
+	}
+
+	sub = depsGraph{
+		Roots:      []string{partial},
+		Imports:    make(map[string][]string),
+		ImportedBy: make(map[string][]string),
+		Outputs:    make(map[string]string),
+		reverse:    true,
+	}
+	queue := []string{partial}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if _, ok := sub.ImportedBy[cur]; ok {
+			continue
+		}
+
+		sub.ImportedBy[cur] = append([]string{}, g.ImportedBy[cur]...)
+		for _, importer := range g.ImportedBy[cur] {
+			sub.Imports[importer] = append(sub.Imports[importer], cur)
+			queue = append(queue, importer)
+		}
+		out, ok := g.Outputs[cur]
+		if ok {
+			sub.Outputs[cur] = out
+		}
+	}
+	return
+}
+
+// writeTree writes the graph as indented tree, starting at its roots.
+// In reverse mode, the children of a file are the files importing it.
+func (g depsGraph) writeTree(w io.Writer) (err error) {
+	children := g.Imports
+	if g.reverse {
+		children = g.ImportedBy
+	}
+
+	var walk func(file, indent string, last, root bool) error
+	walk = func(file, indent string, last, root bool) (err error) {
+		label := file
+		out, ok := g.Outputs[file]
+		if ok {
+			label = fmt.Sprintf("%s (output %s)", file, out)
+		}
+
+		childIndent := indent
+		switch {
+		case root:
+			_, err = fmt.Fprintln(w, label)
+		case last:
+			_, err = fmt.Fprintf(w, "%s└── %s\n", indent, label)
+			childIndent += "    "
+		default:
+			_, err = fmt.Fprintf(w, "%s├── %s\n", indent, label)
+			childIndent += "│   "
+		}
+		if err != nil {
+			return
+		}
+
+		for j, child := range children[file] {
+			err = walk(child, childIndent, j == len(children[file])-1, false)
+			if err != nil {
+				return
+			}
+		}
+		return
+	}
+
+	for _, root := range g.Roots {
+		err = walk(root, "", true, true)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// writeDOT writes the graph in the Graphviz DOT format, edges point from the importing to the imported file.
+// Outputs are drawn as boxes, connected to their templates by dashed edges.
+func (g depsGraph) writeDOT(w io.Writer) (err error) {
+	sb := strings.Builder{}
+	sb.WriteString("digraph yatt {\n")
+	for _, root := range g.Roots {
+		fmt.Fprintf(&sb, "\t%q;\n", root)
+	}
+
+	files := make([]string, 0, len(g.Imports))
+	for file := range g.Imports {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		for _, imp := range g.Imports[file] {
+			fmt.Fprintf(&sb, "\t%q -> %q;\n", file, imp)
+		}
+	}
+
+	templates := make([]string, 0, len(g.Outputs))
+	for file := range g.Outputs {
+		templates = append(templates, file)
+	}
+	sort.Strings(templates)
+	for _, file := range templates {
+		fmt.Fprintf(&sb, "\t%q [shape=box];\n", g.Outputs[file])
+		fmt.Fprintf(&sb, "\t%q -> %q [style=dashed];\n", file, g.Outputs[file])
+	}
+	sb.WriteString("}\n")
+
+	_, err = io.WriteString(w, sb.String())
+	return
+}
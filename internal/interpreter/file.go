@@ -1,21 +1,55 @@
 package interpreter
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xiroxasx/yatt/internal/diagnostic"
+	"github.com/xiroxasx/yatt/internal/sourcemap"
 )
 
 // runFileMode runs the import with the targeted Options.OutPath.
 func (i *Interpreter) runFileMode(inPath, outPath string) (err error) {
-	// First check if we have cyclic dependencies.
-	err = i.core.ImportPathCheckCyclicDependencies(inPath)
+	return i.renderFile(inPath, outPath)
+}
+
+// runStreamMode interprets the template read from stdin.
+// Imports are resolved relative to the working directory.
+func (i *Interpreter) runStreamMode(outPath string) (err error) {
+	// The input needs to be read twice, once for the dependency check and once for the interpretation.
+	in, err := io.ReadAll(i.stdin)
+	if err != nil {
+		return fmt.Errorf("reading stdin: %v", err)
+	}
+
+	err = i.core.CheckCyclicDependencies(stdioPath, bytes.NewReader(in))
 	if err != nil {
 		return fmt.Errorf("dependency check: %v", err)
 	}
 
-	return i.writeInterpretedFile(inPath, outPath)
+	if outPath != stdioPath && !i.opts.Check {
+		err = os.MkdirAll(filepath.Dir(outPath), 0o755)
+		if err != nil {
+			return
+		}
+	}
+
+	sm := i.newSourceMap(stdioPath, outPath)
+	err = i.writeOutput(outPath, func(out io.Writer) error {
+		return i.writeInterpreted(i.core, stdioPath, nopReadAtCloser{bytes.NewReader(in)}, int64(len(in)), out, sm)
+	})
+	if err != nil {
+		return
+	}
+	return writeSourceMap(sm, outPath)
 }
 
 // runDirMode runs the interpreter for each file inside the given path.
@@ -27,15 +61,22 @@ func (i *Interpreter) runDirMode(sourcePath, outPath string) (err error) {
 		return
 	}
 
-	err = filepath.WalkDir(sourcePath, func(inPath string, entry os.DirEntry, err error) error {
-		if err != nil {
+	var (
+		inPaths = make([]string, 0)
+		errs    = make([]fileError, 0)
+	)
+	walkErr := func(inPath string, err error) error {
+		if err == nil || !i.opts.KeepGoing {
 			return err
 		}
 
-		// First check if we have cyclic dependencies.
-		err = i.core.ImportPathCheckCyclicDependencies(inPath)
+		// Keep walking, the error is reported along with the failed renders.
+		errs = append(errs, fileError{path: inPath, err: err})
+		return nil
+	}
+	err = filepath.WalkDir(sourcePath, func(inPath string, entry os.DirEntry, err error) error {
 		if err != nil {
-			return fmt.Errorf("dependency check: %v", err)
+			return walkErr(inPath, err)
 		}
 
 		dest := strings.ReplaceAll(inPath, sourcePath, outPath)
@@ -45,16 +86,129 @@ func (i *Interpreter) runDirMode(sourcePath, outPath string) (err error) {
 				return nil
 			}
 
+			if i.opts.Check {
+				return nil
+			}
+
 			// Create dirs along the way.
-			return os.MkdirAll(dest, dirPerm)
+			return walkErr(inPath, os.MkdirAll(dest, dirPerm))
 		}
 
-		err = i.writeInterpretedFile(inPath, dest)
-		if err != nil {
-			return err
+		if isSidecar(entry.Name()) {
+			// Written by a previous run, if the outputs lie inside the input path.
+			return nil
 		}
-
+		inPaths = append(inPaths, inPath)
 		return nil
 	})
+	if err != nil {
+		return
+	}
+
+	total := len(inPaths) + len(errs)
+	errs = append(errs, i.renderFiles(inPaths, func(inPath string) string {
+		return strings.ReplaceAll(inPath, sourcePath, outPath)
+	})...)
+	return i.fileErrors(errs, total)
+}
+
+// isSidecar reports whether name is the name of a file which is written next to the outputs,
+// either the manifest of the incremental mode or a source map. They are never rendered as templates.
+func isSidecar(name string) bool {
+	return name == manifestName || strings.HasSuffix(name, sourcemap.Extension)
+}
+
+type fileError struct {
+	path string
+	err  error
+}
+
+// renderFiles renders the given files on a pool of Options.Jobs workers.
+// Every file is rendered by its own fork of the core, so that no state is shared between files.
+// After the first failure, no further files are started, unless Options.KeepGoing is set.
+func (i *Interpreter) renderFiles(inPaths []string, destPath func(inPath string) string) (errs []fileError) {
+	var (
+		wg     sync.WaitGroup
+		mx     sync.Mutex
+		failed atomic.Bool
+		queue  = make(chan string)
+	)
+	errs = make([]fileError, 0)
+
+	for range max(i.opts.Jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for inPath := range queue {
+				fErr := i.renderFile(inPath, destPath(inPath))
+				if fErr == nil {
+					continue
+				}
+
+				failed.Store(true)
+				mx.Lock()
+				errs = append(errs, fileError{path: inPath, err: fErr})
+				mx.Unlock()
+			}
+		}()
+	}
+
+	for _, inPath := range inPaths {
+		if failed.Load() && !i.opts.KeepGoing {
+			break
+		}
+		queue <- inPath
+	}
+	close(queue)
+	wg.Wait()
 	return
 }
+
+// fileErrors combines the errors of the dir mode into a single error.
+// With Options.KeepGoing, every error is reported right away and only a summary is returned,
+// so that all broken templates are listed by a single run.
+func (i *Interpreter) fileErrors(errs []fileError, total int) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	// Keep the reported errors in a stable order, regardless of the scheduling.
+	sort.Slice(errs, func(a, b int) bool {
+		return errs[a].path < errs[b].path
+	})
+	if i.opts.KeepGoing {
+		for _, fe := range errs {
+			i.logError(fe.err, fe.path, "render failed")
+		}
+		return fmt.Errorf("%w: %d of %d files", ErrFilesFailed, len(errs), total)
+	}
+
+	joined := make([]error, len(errs))
+	for j, fe := range errs {
+		// Diagnostics already start with their file.
+		var d *diagnostic.Diagnostic
+		if errors.As(fe.err, &d) {
+			joined[j] = fe.err
+			continue
+		}
+		joined[j] = fmt.Errorf("%s: %w", fe.path, fe.err)
+	}
+	return errors.Join(joined...)
+}
+
+// renderFile renders a single file with its own fork of the core.
+func (i *Interpreter) renderFile(inPath, outPath string) (err error) {
+	c := i.core.Fork()
+
+	// First check if we have cyclic dependencies.
+	err = c.ImportPathCheckCyclicDependencies(inPath)
+	if err != nil {
+		return fmt.Errorf("dependency check: %w", err)
+	}
+
+	if i.manifest != nil {
+		return i.renderFileIncremental(c, inPath, outPath)
+	}
+	return i.writeInterpretedFile(c, inPath, outPath)
+}
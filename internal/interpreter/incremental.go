@@ -0,0 +1,237 @@
+package interpreter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/xiroxasx/yatt/internal/core"
+)
+
+const (
+	// manifestName is the file name of the manifest which is stored next to the outputs.
+	manifestName    = ".yatt-manifest.json"
+	manifestVersion = 1
+)
+
+// manifest records the inputs of every rendered output,
+// so that unchanged outputs can be skipped on the next run.
+type manifest struct {
+	Version int                      `json:"version"`
+	Outputs map[string]manifestEntry `json:"outputs"`
+
+	path string
+	mx   *sync.Mutex
+}
+
+type manifestEntry struct {
+	Template string `json:"template"`
+	// Inputs contains the content hashes of the template, all transitive imports and var files.
+	Inputs map[string]string `json:"inputs"`
+	// Options contains the hash of all options which affect the rendered content.
+	Options string `json:"options"`
+	// Output contains the content hash of the rendered output.
+	Output string `json:"output"`
+}
+
+// manifestPath returns the path of the manifest for the given output path.
+func manifestPath(outPath string, isDir bool) string {
+	if isDir {
+		return filepath.Join(outPath, manifestName)
+	}
+	return filepath.Join(filepath.Dir(outPath), manifestName)
+}
+
+// loadManifest reads the manifest at path.
+// A missing or outdated manifest results in an empty one.
+func loadManifest(path string) (m *manifest, err error) {
+	m = &manifest{
+		Version: manifestVersion,
+		Outputs: make(map[string]manifestEntry),
+		path:    path,
+		mx:      &sync.Mutex{},
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+
+	stored := manifest{}
+	err = json.Unmarshal(b, &stored)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse manifest %s: %v", path, err)
+	}
+	if stored.Version != manifestVersion || stored.Outputs == nil {
+		// Render everything again, the stored entries can not be compared.
+		return
+	}
+
+	m.Outputs = stored.Outputs
+	return
+}
+
+func (m *manifest) entry(outPath string) (e manifestEntry, ok bool) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	e, ok = m.Outputs[outPath]
+	return
+}
+
+func (m *manifest) setEntry(outPath string, e manifestEntry) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	m.Outputs[outPath] = e
+}
+
+// save writes the manifest, entries of templates which no longer exist are dropped.
+func (m *manifest) save() (err error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	for outPath, e := range m.Outputs {
+		_, sErr := os.Stat(e.Template)
+		if errors.Is(sErr, fs.ErrNotExist) {
+			delete(m.Outputs, outPath)
+		}
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(m.path), 0o755)
+	if err != nil {
+		return
+	}
+	return os.WriteFile(m.path, b, 0o644)
+}
+
+func (e manifestEntry) sameInputs(other manifestEntry) bool {
+	return e.Template == other.Template &&
+		e.Options == other.Options &&
+		maps.Equal(e.Inputs, other.Inputs)
+}
+
+// renderFileIncremental skips the rendering if none of the recorded inputs changed.
+// Rendered outputs are only written if their content differs from the existing file.
+func (i *Interpreter) renderFileIncremental(c *core.Core, inPath, outPath string) (err error) {
+	entry := manifestEntry{
+		Template: inPath,
+		Inputs:   make(map[string]string),
+		Options:  i.optionsHash(),
+	}
+
+	inputs := append([]string{inPath}, c.Dependencies(inPath)...)
+	for _, vf := range i.opts.VarFilePaths {
+		inputs = append(inputs, core.VarFilePath(vf))
+	}
+	inputs = append(inputs, i.setFilePaths()...)
+	for _, in := range inputs {
+		entry.Inputs[in], err = hashFile(in)
+		if err != nil {
+			return
+		}
+	}
+
+	prev, ok := i.manifest.entry(outPath)
+	if ok && prev.sameInputs(entry) {
+		outHash, hErr := hashFile(outPath)
+		if hErr == nil && outHash == prev.Output {
+			i.l.Debug().Str("file", outPath).Msg("inputs unchanged, skipped")
+			i.stats.add(fileStats{Path: inPath, Mode: statsModeSkipped})
+			return
+		}
+	}
+
+	rendered := &bytes.Buffer{}
+	sm := i.newSourceMap(inPath, outPath)
+	err = i.renderTo(c, inPath, rendered, sm)
+	if err != nil {
+		return
+	}
+	entry.Output = hashBytes(rendered.Bytes())
+
+	existing, rErr := os.ReadFile(outPath)
+	if rErr != nil || !bytes.Equal(existing, rendered.Bytes()) {
+		err = i.writeOutput(outPath, func(out io.Writer) error {
+			_, wErr := out.Write(rendered.Bytes())
+			return wErr
+		})
+		if err != nil {
+			return
+		}
+	} else {
+		i.l.Debug().Str("file", outPath).Msg("content unchanged, not written")
+	}
+
+	// The source map follows its output, it is neither written for a failed output nor rewritten if unchanged.
+	err = writeSourceMap(sm, outPath)
+	if err != nil {
+		return
+	}
+	i.manifest.setEntry(outPath, entry)
+	return
+}
+
+// optionsHash returns the hash of all options which affect the rendered content.
+func (i *Interpreter) optionsHash() string {
+	b, _ := json.Marshal(struct {
+		Indent        bool
+		FileWhitelist []string
+		FileBlacklist []string
+		VarFilePaths  []string
+		Set           []string
+		SetFiles      []string
+		Prefixes      []string
+		TemplateStart string
+		TemplateEnd   string
+		LineEnding    string
+		Strict        bool
+		Passthrough   bool
+		SourceMap     bool
+	}{
+		Indent:        i.opts.Indent,
+		FileWhitelist: i.opts.FileWhitelist,
+		FileBlacklist: i.opts.FileBlacklist,
+		VarFilePaths:  i.opts.VarFilePaths,
+		Set:           i.opts.Set,
+		SetFiles:      i.opts.SetFiles,
+		Prefixes:      i.opts.Prefixes,
+		TemplateStart: i.opts.TemplateStart,
+		TemplateEnd:   i.opts.TemplateEnd,
+		LineEnding:    i.opts.LineEnding,
+		Strict:        i.opts.Strict,
+		Passthrough:   i.opts.Passthrough,
+		SourceMap:     i.opts.SourceMap,
+	})
+	return hashBytes(b)
+}
+
+func hashFile(path string) (_ string, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	return hashBytes(b), nil
+}
+
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -2,22 +2,55 @@ package interpreter
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/xiroxasx/yatt/internal/common"
 	"github.com/xiroxasx/yatt/internal/core"
+	"github.com/xiroxasx/yatt/internal/diagnostic"
+	"github.com/xiroxasx/yatt/internal/sourcemap"
 )
 
+// stdioPath is used as in or out path to read from stdin or write to stdout.
+const stdioPath = "-"
+
+// Line ending modes of Options.LineEnding.
+const (
+	LineEndingLF   = "lf"
+	LineEndingCRLF = "crlf"
+	// LineEndingAuto keeps the line ending of every input file, including its trailing line ending.
+	LineEndingAuto = "auto"
+)
+
+// ErrFilesFailed is returned by the dir mode with Options.KeepGoing if any file failed.
+var ErrFilesFailed = errors.New("render failed")
+
+// ErrInvalidSet is returned for -set and -set-file values which are not of the form name=value.
+var ErrInvalidSet = errors.New("value must be of the form name=value")
+
+var errInvalidLineEnding = fmt.Errorf("line ending must be one of %s, %s or %s", LineEndingLF, LineEndingCRLF, LineEndingAuto)
+
 type Interpreter struct {
 	l    zerolog.Logger
 	core *core.Core
 
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	report   checkReport
+	manifest *manifest
+	// stats are collected by Start, they are nil otherwise.
+	stats *runStats
+
 	opts *Options
 }
 
@@ -27,97 +60,351 @@ type Options struct {
 	FileWhitelist []string
 	FileBlacklist []string
 	VarFilePaths  []string
-	Indent        bool
-	NoStats       bool
-	Verbose       bool
+	// Set contains global variables of the form "name=value", which take precedence over the var files.
+	Set []string
+	// SetFiles contains global variables of the form "name=path", whose values are read from the file at path.
+	// Set takes precedence over SetFiles.
+	SetFiles []string
+	Indent   bool
+	NoStats  bool
+	// Stats prints the statistics of every rendered file after a run, instead of a single summary line.
+	Stats bool
+	// StatsFormat is the format of the statistics printed after a run, either StatsFormatText or StatsFormatJSON.
+	StatsFormat string
+	Verbose     bool
+	// Trace logs every evaluation step of the core, e.g. taken branches, variable lookups and function calls.
+	Trace       bool
+	Watch       bool
+	Check       bool
+	Incremental bool
+	// Strict makes unresolved variables an error instead of an empty value.
+	Strict bool
+	// Passthrough keeps unknown tokens verbatim instead of rendering them empty.
+	Passthrough bool
+	// SourceMap writes a source map next to every output, see sourcemap.Path.
+	SourceMap bool
+	// Prefixes are the directive prefixes, defaults to core.DefaultPrefixes.
+	Prefixes []string
+	// TemplateStart and TemplateEnd delimit variables and functions, default to "{{" and "}}".
+	TemplateStart string
+	TemplateEnd   string
+	// LineEnding is one of LineEndingLF, LineEndingCRLF or LineEndingAuto.
+	// Defaults to the line ending of the current OS.
+	LineEnding string
+	// Diagnostics is the format of reported errors, either diagnostic.FormatText or diagnostic.FormatJSON.
+	Diagnostics string
+	// Jobs is the amount of files rendered concurrently in dir mode.
+	Jobs int
+	// KeepGoing renders all files of the dir mode, even if some of them fail.
+	// Failed files are not written, their errors are reported at the end of the run.
+	KeepGoing bool
+	// Transactional stages all outputs of the dir mode and only replaces the output directory
+	// if every file has been rendered successfully.
+	Transactional bool
 }
 
-func defaultPrefixTokens() []string {
-	const prefixName = "yatt"
-
-	return []string{
-		fmt.Sprintf("#%s", prefixName),
-		fmt.Sprintf("# %s", prefixName),
-		fmt.Sprintf("//%s", prefixName),
-		fmt.Sprintf("// %s", prefixName),
+func New(l zerolog.Logger, opts *Options) (i *Interpreter, err error) {
+	i = &Interpreter{
+		opts:   opts,
+		l:      l,
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	switch opts.Diagnostics {
+	case "", diagnostic.FormatText, diagnostic.FormatJSON:
+	default:
+		return nil, diagnostic.ErrInvalidFormat
+	}
+	switch opts.StatsFormat {
+	case "", StatsFormatText, StatsFormatJSON:
+	default:
+		return nil, ErrInvalidStatsFormat
 	}
+	i.core, err = i.newCore()
+	return
 }
 
-func New(l zerolog.Logger, opts *Options) (i *Interpreter) {
-	i = &Interpreter{
-		opts: opts,
-		l:    l,
-		core: core.New(l, defaultPrefixTokens(), core.Options{
-			PreserveIndent: opts.Indent,
-		}),
+// newCore creates a fresh core with the global variables loaded.
+func (i *Interpreter) newCore() (c *core.Core, err error) {
+	prefixes := i.opts.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = core.DefaultPrefixes()
+	}
+	le, err := lineEndingBytes(i.opts.LineEnding)
+	if err != nil {
+		return
+	}
+	coreOpts := core.Options{
+		PreserveIndent: i.opts.Indent,
+		LineEnding:     le,
+		TemplateStart:  []byte(i.opts.TemplateStart),
+		TemplateEnd:    []byte(i.opts.TemplateEnd),
+		Strict:         i.opts.Strict,
+		Passthrough:    i.opts.Passthrough,
+		Trace:          i.opts.Trace,
+	}
+	err = core.Validate(prefixes, coreOpts)
+	if err != nil {
+		return
 	}
 
-	i.initScopedVars()
+	c = core.New(i.l, prefixes, coreOpts)
+	err = i.initScopedVars(c)
 	return
 }
 
 func (i *Interpreter) Start() (err error) {
 	i.opts.InPath = filepath.Clean(i.opts.InPath)
-
-	stat, err := os.Stat(i.opts.InPath)
-	if err != nil {
-		i.l.Fatal().Err(err).Msg("unable to stat input path")
-	}
+	i.opts.OutPath = filepath.Clean(i.opts.OutPath)
 
 	start := time.Now()
+	i.stats = newRunStats()
 	defer func() {
-		if err != nil {
+		if err != nil || i.opts.NoStats {
 			return
 		}
 
-		elapsed := time.Since(start)
-		if !i.opts.NoStats {
-			i.l.Info().Dur("elapsed", elapsed).Msg("finished")
+		i.stats.Duration = time.Since(start)
+		if !i.opts.Stats {
+			i.l.Info().Dur("elapsed", i.stats.Duration).Msg("finished")
+			return
+		}
+		wErr := i.stats.write(i.stderr, i.opts.StatsFormat)
+		if wErr != nil {
+			i.l.Err(wErr).Msg("unable to write stats")
 		}
 	}()
 
-	if stat.IsDir() {
-		i.opts.OutPath = filepath.Clean(i.opts.OutPath)
-		err = os.MkdirAll(i.opts.OutPath, 0o755)
+	if i.opts.Check {
+		if i.opts.OutPath == stdioPath {
+			return errors.New("check mode requires an output path")
+		}
+
+		i.report = newCheckReport()
+		defer func() {
+			if err == nil {
+				err = i.report.err()
+			}
+		}()
+	}
+
+	if i.opts.InPath == stdioPath {
+		err = i.runStreamMode(i.opts.OutPath)
+		return
+	}
+
+	stat, err := os.Stat(i.opts.InPath)
+	if err != nil {
+		return fmt.Errorf("unable to stat input path: %v", err)
+	}
+
+	if i.opts.Incremental && i.opts.Transactional {
+		return errors.New("incremental mode cannot be combined with transactional mode")
+	}
+	if i.opts.Incremental && !i.opts.Check {
+		i.manifest, err = loadManifest(manifestPath(i.opts.OutPath, stat.IsDir()))
 		if err != nil {
 			return
 		}
+		defer func() {
+			// Also persist the successful renders if some files failed.
+			sErr := i.manifest.save()
+			if err == nil {
+				err = sErr
+			}
+		}()
+	}
+
+	if stat.IsDir() {
+		if i.opts.OutPath == stdioPath {
+			return errors.New("directories cannot be written to stdout")
+		}
+
+		if i.opts.Transactional && !i.opts.Check {
+			return i.runDirModeTransactional(i.opts.InPath, i.opts.OutPath)
+		}
+		if !i.opts.Check {
+			err = os.MkdirAll(i.opts.OutPath, 0o755)
+			if err != nil {
+				return
+			}
+		}
 
 		err = i.runDirMode(i.opts.InPath, i.opts.OutPath)
+		if err != nil || !i.opts.Check {
+			return
+		}
+		err = i.checkExtraOutputs(i.opts.OutPath)
 		return
 	}
 
-	outDir := filepath.Dir(filepath.Clean(i.opts.OutPath))
-	err = os.MkdirAll(outDir, 0o755)
-	if err != nil {
-		return
+	if i.opts.OutPath != stdioPath && !i.opts.Check {
+		outDir := filepath.Dir(i.opts.OutPath)
+		err = os.MkdirAll(outDir, 0o755)
+		if err != nil {
+			return
+		}
 	}
 	err = i.runFileMode(i.opts.InPath, i.opts.OutPath)
 	return
 }
 
-func (i *Interpreter) initScopedVars() {
+// lineEndingBytes returns the line ending of the given mode.
+// For LineEndingAuto, the OS line ending is used until a file's line ending is detected.
+func lineEndingBytes(mode string) (le []byte, err error) {
+	switch mode {
+	case "", LineEndingAuto:
+		return []byte(lineEnding), nil
+	case LineEndingLF:
+		return []byte("\n"), nil
+	case LineEndingCRLF:
+		return []byte("\r\n"), nil
+	}
+	return nil, errInvalidLineEnding
+}
+
+// logError logs err, or writes its diagnostics to stderr if the JSON format is used.
+func (i *Interpreter) logError(err error, file, msg string) {
+	if i.opts.Diagnostics != diagnostic.FormatJSON {
+		i.l.Err(err).Str("file", file).Msg(msg)
+		return
+	}
+
+	wErr := diagnostic.WriteJSON(i.stderr, err)
+	if wErr != nil {
+		i.l.Err(wErr).Msg("unable to write diagnostics")
+	}
+}
+
+func (i *Interpreter) initScopedVars(c *core.Core) error {
 	// Cleanup filepaths.
 	vFiles := i.opts.VarFilePaths
 	for i, vFile := range vFiles {
 		vFiles[i] = filepath.Clean(vFile)
 	}
 
-	i.core.InitGlobalVariablesByFiles(vFiles...)
-}
-
-func (i *Interpreter) writeInterpretedFile(inPath, outPath string) (err error) {
-	out, err := os.OpenFile(outPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0700)
+	err := c.InitGlobalVariablesByFiles(vFiles...)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		cErr := out.Close()
-		if err == nil {
-			err = cErr
+	return i.initOverrides(c)
+}
+
+// initOverrides sets the variables of Options.SetFiles and Options.Set, in this order.
+func (i *Interpreter) initOverrides(c *core.Core) (err error) {
+	for _, arg := range i.opts.SetFiles {
+		name, path, ok := splitSet(arg)
+		if !ok {
+			return fmt.Errorf("set-file %q: %w", arg, ErrInvalidSet)
 		}
-	}()
+		var b []byte
+		b, err = os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("set-file %s: %v", name, err)
+		}
+		// The trailing line ending of the file is not part of the value.
+		b = bytes.TrimSuffix(b, []byte{'\n'})
+		b = bytes.TrimSuffix(b, []byte{'\r'})
+		err = c.SetOverrideVariable(name, string(b))
+		if err != nil {
+			return
+		}
+	}
+
+	for _, arg := range i.opts.Set {
+		name, value, ok := splitSet(arg)
+		if !ok {
+			return fmt.Errorf("set %q: %w", arg, ErrInvalidSet)
+		}
+		err = c.SetOverrideVariable(name, value)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// setFilePaths returns the cleaned paths of Options.SetFiles.
+func (i *Interpreter) setFilePaths() (paths []string) {
+	for _, arg := range i.opts.SetFiles {
+		_, path, ok := splitSet(arg)
+		if ok {
+			paths = append(paths, filepath.Clean(path))
+		}
+	}
+	return
+}
+
+// splitSet splits arg of the form "name=value" into its name and value.
+func splitSet(arg string) (name, value string, ok bool) {
+	name, value, ok = strings.Cut(arg, "=")
+	name = strings.TrimSpace(name)
+	return name, value, ok && name != ""
+}
+
+// writeInterpretedFile renders the file at inPath with c and writes the result to outPath.
+// The output is only opened once the file has been rendered successfully, so that failed renders don't truncate it.
+func (i *Interpreter) writeInterpretedFile(c *core.Core, inPath, outPath string) (err error) {
+	sm := i.newSourceMap(inPath, outPath)
+	rendered := &bytes.Buffer{}
+	err = i.renderTo(c, inPath, rendered, sm)
+	if err != nil {
+		return
+	}
+
+	err = i.writeOutput(outPath, func(out io.Writer) error {
+		_, wErr := out.Write(rendered.Bytes())
+		return wErr
+	})
+	if err != nil {
+		return
+	}
+	return writeSourceMap(sm, outPath)
+}
+
+// newSourceMap returns the source map of the output at outPath, or nil if no source map is written.
+// Raw copies have no template lines to map to.
+func (i *Interpreter) newSourceMap(inPath, outPath string) *sourcemap.Map {
+	if !i.opts.SourceMap || i.opts.Check || outPath == stdioPath || i.isRawCopy(inPath) {
+		return nil
+	}
+	return sourcemap.New()
+}
 
+// writeSourceMap writes sm next to the output at outPath, if sm is not nil.
+// Just like outputs, the source map is replaced atomically. An unchanged source map is not written again.
+func writeSourceMap(sm *sourcemap.Map, outPath string) (err error) {
+	if sm == nil {
+		return
+	}
+
+	b := &bytes.Buffer{}
+	err = sm.Write(b)
+	if err != nil {
+		return
+	}
+	path := sourcemap.Path(outPath)
+	existing, rErr := os.ReadFile(path)
+	if rErr == nil && bytes.Equal(existing, b.Bytes()) {
+		return
+	}
+
+	f, err := createAtomicFile(path, 0o644)
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b.Bytes())
+	if err != nil {
+		_ = f.Abort()
+		return
+	}
+	return f.Close()
+}
+
+// renderTo renders the file at inPath with c and writes the result to out.
+// The origin of every output line is recorded in sm, if it is not nil.
+func (i *Interpreter) renderTo(c *core.Core, inPath string, out io.Writer, sm *sourcemap.Map) (err error) {
 	// Copy file contents if the current file is matching the filters,
 	// we don't need to interpret them.
 	isRaw, err := i.rawCopyOnListMatch(inPath, out)
@@ -135,27 +422,170 @@ func (i *Interpreter) writeInterpretedFile(inPath, outPath string) (err error) {
 		return
 	}
 
+	stat, err := inFile.Stat()
+	if err != nil {
+		inFile.Close()
+		return
+	}
+
+	return i.writeInterpreted(c, inPath, inFile, stat.Size(), out, sm)
+}
+
+// writeOutput passes the opened output of outPath to write.
+// In check mode, the written content is compared against the existing output instead.
+func (i *Interpreter) writeOutput(outPath string, write func(out io.Writer) error) (err error) {
+	if i.opts.Check {
+		return i.checkOutput(outPath, write)
+	}
+
+	out, err := i.openOutput(outPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			// Keep the previous output.
+			aErr := out.Abort()
+			if aErr != nil {
+				i.l.Err(aErr).Str("file", outPath).Msg("unable to discard output")
+			}
+			return
+		}
+		err = out.Close()
+	}()
+
+	return write(out)
+}
+
+// input is the content of a template which can be read sequentially and at arbitrary offsets.
+type input interface {
+	io.ReadCloser
+	io.ReaderAt
+}
+
+// writeInterpreted interprets the content of in with c and writes the result to out.
+// The size is used to detect the trailing line ending in auto mode.
+// in is always closed.
+func (i *Interpreter) writeInterpreted(c *core.Core, name string, in input, size int64, out io.Writer, sm *sourcemap.Map) (err error) {
+	// Every interpreted line is terminated by a line ending, cut the last one by default.
+	trimLast := true
+	if i.opts.LineEnding == LineEndingAuto {
+		var (
+			le       []byte
+			trailing bool
+		)
+		le, trailing, err = detectLineEnding(in, size)
+		if err != nil {
+			in.Close()
+			return
+		}
+		if le != nil {
+			c.SetLineEnding(le)
+		}
+		trimLast = !trailing
+	}
+
+	start := time.Now()
 	buf := &bytes.Buffer{}
 	interFile := core.InterpreterFile{
-		Name: inPath,
-		RC:   inFile,
-		Buf:  buf,
+		Name:      name,
+		RC:        in,
+		Buf:       buf,
+		SourceMap: sm,
 	}
 	// Write to the buffer to ensure that files don't get partially written.
-	err = i.core.Interpret(interFile)
+	err = c.Interpret(interFile)
 	if err != nil {
 		return
 	}
 
-	if buf.Len() > 0 {
-		// Write buffer to the file and cut last new line.
-		_, err = out.Write(buf.Bytes()[:buf.Len()-1])
+	b := buf.Bytes()
+	if trimLast {
+		b = bytes.TrimSuffix(b, c.LineEnding())
 	}
+	_, err = out.Write(b)
+	if err != nil {
+		return
+	}
+
+	i.stats.add(fileStats{
+		Path:     name,
+		Mode:     statsModeInterpreted,
+		Duration: time.Since(start),
+		BytesIn:  size,
+		BytesOut: int64(len(b)),
+		Stats:    c.Stats(),
+	})
 	return
 }
 
+// detectLineEnding returns the line ending of the first line of r and whether r ends with a line ending.
+// If r does not contain any line ending, le is nil.
+// Only the first line and the last byte are read, so that long lines don't need to be held in memory.
+func detectLineEnding(r io.ReaderAt, size int64) (le []byte, trailing bool, err error) {
+	if size == 0 {
+		return
+	}
+
+	last := make([]byte, 1)
+	_, err = r.ReadAt(last, size-1)
+	if err != nil {
+		return
+	}
+	trailing = last[0] == '\n'
+
+	// Keep the last byte of the previous chunk to detect CRLF line endings across chunks.
+	chunk := make([]byte, 32*1024+1)
+	for off := int64(0); off < size; off += int64(len(chunk) - 1) {
+		n, rErr := r.ReadAt(chunk[1:], off)
+		if rErr != nil && !errors.Is(rErr, io.EOF) {
+			return nil, false, rErr
+		}
+
+		var ok bool
+		le, ok = common.DetectLineEnding(chunk[:n+1])
+		if ok {
+			return le, trailing, nil
+		}
+		chunk[0] = chunk[n]
+	}
+	return nil, trailing, nil
+}
+
+// openOutput opens the given output path for writing.
+// If the path equals stdioPath, stdout is used instead.
+func (i *Interpreter) openOutput(outPath string) (output, error) {
+	if outPath == stdioPath {
+		return nopWriteCloser{i.stdout}, nil
+	}
+	return createAtomicFile(outPath, 0700)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Abort implements the output interface.
+// Content written to stdout cannot be discarded.
+func (nopWriteCloser) Abort() error {
+	return nil
+}
+
+type nopReadAtCloser struct {
+	*bytes.Reader
+}
+
+func (nopReadAtCloser) Close() error {
+	return nil
+}
+
 func (i *Interpreter) rawCopyOnListMatch(inPath string, out io.Writer) (isRaw bool, err error) {
 	writeTo := func(inPath string, out io.Writer) (err error) {
+		start := time.Now()
 		var b []byte
 		b, err = os.ReadFile(inPath)
 		if err != nil {
@@ -167,26 +597,35 @@ func (i *Interpreter) rawCopyOnListMatch(inPath string, out io.Writer) (isRaw bo
 		if err != nil {
 			return
 		}
-		return
-	}
 
-	if i.matchedBlacklist(inPath) {
-		log.Debug().Str("file", inPath).Msg("matched blacklist, plain copy")
-		isRaw = true
-		err = writeTo(inPath, out)
+		i.stats.add(fileStats{
+			Path:     inPath,
+			Mode:     statsModeRaw,
+			Duration: time.Since(start),
+			BytesIn:  int64(len(b)),
+			BytesOut: int64(len(b)),
+		})
 		return
 	}
 
-	if len(i.opts.FileWhitelist) == 0 || i.matchedWhitelist(inPath) {
+	if !i.isRawCopy(inPath) {
 		return
 	}
 
-	log.Debug().Str("file", inPath).Msg("does not match whitelist, plain copy")
+	log.Debug().Str("file", inPath).Msg("matched blacklist or does not match whitelist, plain copy")
 	isRaw = true
 	err = writeTo(inPath, out)
 	return
 }
 
+// isRawCopy reports whether the file at inPath is copied without being interpreted.
+func (i *Interpreter) isRawCopy(inPath string) bool {
+	if i.matchedBlacklist(inPath) {
+		return true
+	}
+	return len(i.opts.FileWhitelist) > 0 && !i.matchedWhitelist(inPath)
+}
+
 func (i *Interpreter) matchedBlacklist(v string) (matched bool) {
 	if i.opts == nil {
 		return
@@ -2,26 +2,33 @@ package interpreter
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	r "github.com/stretchr/testify/require"
 	"github.com/xiroxasx/yatt/internal/core"
+	"github.com/xiroxasx/yatt/internal/diagnostic"
+	"github.com/xiroxasx/yatt/internal/sourcemap"
 )
 
 func TestFileInterpretation(t *testing.T) {
-	rootDir := filepath.Join("testdata", "interpret")
-	rootInDir := filepath.Join(rootDir, "in")
-	rootOutDir := filepath.Join(rootDir, "out")
+	rootInDir := filepath.Join("testdata", "interpret", "in")
+	rootOutDir := t.TempDir()
 	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	ip := New(l, &Options{
+	ip, err := New(l, &Options{
 		VarFilePaths: []string{filepath.Join(rootInDir, "yatt.var")},
 		Indent:       true,
 		NoStats:      true,
 	})
+	r.NoError(t, err)
 
 	// file gets closed by the Interpret call.
 	rootFileIn := filepath.Join(rootInDir, "rootfile.yaml")
@@ -44,17 +51,17 @@ func TestFileInterpretation(t *testing.T) {
 }
 
 func TestStart(t *testing.T) {
-	rootDir := filepath.Join("testdata", "interpret")
-	rootInDir := filepath.Join(rootDir, "in")
-	rootOutDir := filepath.Join(rootDir, "out")
+	rootInDir := filepath.Join("testdata", "interpret", "in")
+	rootOutDir := t.TempDir()
 	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	ip := New(l, &Options{
+	ip, err := New(l, &Options{
 		InPath:       filepath.Join(rootInDir, "rootfile.yaml"),
 		OutPath:      filepath.Join(rootOutDir, "rootfile.yaml"),
 		VarFilePaths: []string{filepath.Join(rootInDir, "yatt.var")},
 		Indent:       true,
 		NoStats:      true,
 	})
+	r.NoError(t, err)
 
 	out, err := os.OpenFile(filepath.Join(rootOutDir, "rootfile.yaml"), os.O_TRUNC|os.O_CREATE|os.O_RDWR, 0o755)
 	r.NoError(t, err)
@@ -68,11 +75,10 @@ func TestStart(t *testing.T) {
 }
 
 func TestStartDirMode(t *testing.T) {
-	rootDir := filepath.Join("testdata", "interpret")
-	rootInDir := filepath.Join(rootDir, "in")
-	rootOutDir := filepath.Join(rootDir, "out")
+	rootInDir := filepath.Join("testdata", "interpret", "in")
+	rootOutDir := t.TempDir()
 	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	ip := New(l, &Options{
+	ip, err := New(l, &Options{
 		InPath:        rootInDir,
 		OutPath:       rootOutDir,
 		FileBlacklist: []string{"raw-copy.yaml"},
@@ -80,10 +86,851 @@ func TestStartDirMode(t *testing.T) {
 		Indent:        true,
 		NoStats:       true,
 	})
+	r.NoError(t, err)
+
+	err = os.Setenv("TEST", "environment_variable")
+	r.NoError(t, err)
+	r.NoError(t, ip.Start())
+}
 
+func TestStartDirModeJobs(t *testing.T) {
+	rootInDir := filepath.Join("testdata", "interpret", "in")
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	err := os.Setenv("TEST", "environment_variable")
 	r.NoError(t, err)
+
+	render := func(jobs int) (outDir string) {
+		outDir = t.TempDir()
+		ip, err := New(l, &Options{
+			InPath:        rootInDir,
+			OutPath:       outDir,
+			FileBlacklist: []string{"raw-copy.yaml"},
+			VarFilePaths:  []string{filepath.Join(rootInDir, "yatt.var")},
+			Indent:        true,
+			NoStats:       true,
+			Jobs:          jobs,
+		})
+		r.NoError(t, err)
+		r.NoError(t, ip.Start())
+		return
+	}
+	sequential := render(1)
+	concurrent := render(8)
+
+	err = filepath.WalkDir(sequential, func(path string, entry os.DirEntry, err error) error {
+		r.NoError(t, err)
+		if entry.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(sequential, path)
+		r.NoError(t, err)
+		expected, err := os.ReadFile(path)
+		r.NoError(t, err)
+		actual, err := os.ReadFile(filepath.Join(concurrent, rel))
+		r.NoError(t, err)
+		r.Exactly(t, string(expected), string(actual), "file=%s", rel)
+		return nil
+	})
+	r.NoError(t, err)
+}
+
+func TestStartDirModeKeepGoing(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	outDir := filepath.Join(rootDir, "out")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+	r.NoError(t, os.MkdirAll(outDir, 0o700))
+
+	files := map[string]string{
+		"a.txt": "{{uper(x)}}\n",
+		"b.txt": "ok\n",
+		"c.txt": "# yatt import " + filepath.Join(rootDir, "missing.txt") + "\n",
+	}
+	for name, content := range files {
+		r.NoError(t, os.WriteFile(filepath.Join(inDir, name), []byte(content), 0o600))
+	}
+	// Existing outputs of failed files are kept.
+	r.NoError(t, os.WriteFile(filepath.Join(outDir, "a.txt"), []byte("previous"), 0o600))
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	opts := &Options{
+		InPath:      inDir,
+		OutPath:     outDir,
+		Diagnostics: diagnostic.FormatJSON,
+		NoStats:     true,
+		KeepGoing:   true,
+		Jobs:        1,
+	}
+	ip, err := New(l, opts)
+	r.NoError(t, err)
+	stderr := &bytes.Buffer{}
+	ip.stderr = stderr
+	err = ip.Start()
+	r.ErrorIs(t, err, ErrFilesFailed)
+	r.ErrorContains(t, err, "2 of 3 files")
+
+	// Every failed file is reported, not only the first one.
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	r.Len(t, lines, 2)
+	r.Contains(t, lines[0], `"file":"`+filepath.Join(inDir, "a.txt")+`"`)
+	r.Contains(t, lines[1], `"file":"`+filepath.Join(inDir, "c.txt")+`"`)
+
+	b, err := os.ReadFile(filepath.Join(outDir, "a.txt"))
+	r.NoError(t, err)
+	r.Exactly(t, "previous", string(b))
+	b, err = os.ReadFile(filepath.Join(outDir, "b.txt"))
+	r.NoError(t, err)
+	r.Exactly(t, "ok", string(b))
+	_, err = os.Stat(filepath.Join(outDir, "c.txt"))
+	r.ErrorIs(t, err, os.ErrNotExist)
+
+	// Without keep going, the errors are returned instead.
+	opts.KeepGoing = false
+	ip, err = New(l, opts)
+	r.NoError(t, err)
+	err = ip.Start()
+	r.Error(t, err)
+	r.NotErrorIs(t, err, ErrFilesFailed)
+	// The diagnostics are not prefixed by their file a second time.
+	r.Exactly(t, 1, strings.Count(err.Error(), filepath.Join(inDir, "a.txt")), err.Error())
+}
+
+func TestStartAtomicWrite(t *testing.T) {
+	rootDir := t.TempDir()
+	in := filepath.Join(rootDir, "in.txt")
+	out := filepath.Join(rootDir, "out.txt")
+	r.NoError(t, os.WriteFile(in, []byte("{{uper(x)}}\n"), 0o600))
+	r.NoError(t, os.WriteFile(out, []byte("previous"), 0o640))
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	opts := &Options{
+		InPath:  in,
+		OutPath: out,
+		NoStats: true,
+	}
+	ip, err := New(l, opts)
+	r.NoError(t, err)
+	r.Error(t, ip.Start())
+
+	// Failed renders neither truncate the output nor leave temporary files behind.
+	b, err := os.ReadFile(out)
+	r.NoError(t, err)
+	r.Exactly(t, "previous", string(b))
+	entries, err := os.ReadDir(rootDir)
+	r.NoError(t, err)
+	r.Len(t, entries, 2)
+
+	r.NoError(t, os.WriteFile(in, []byte("{{upper(x)}}\n"), 0o600))
+	r.NoError(t, ip.Start())
+	b, err = os.ReadFile(out)
+	r.NoError(t, err)
+	r.Exactly(t, "X", string(b))
+	stat, err := os.Stat(out)
+	r.NoError(t, err)
+	r.Exactly(t, os.FileMode(0o640), stat.Mode().Perm())
+	entries, err = os.ReadDir(rootDir)
+	r.NoError(t, err)
+	r.Len(t, entries, 2)
+}
+
+func TestStartTransactional(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	outDir := filepath.Join(rootDir, "out")
+	r.NoError(t, os.MkdirAll(filepath.Join(inDir, "sub"), 0o700))
+	r.NoError(t, os.MkdirAll(outDir, 0o700))
+
+	templateB := filepath.Join(inDir, "sub", "b.txt")
+	r.NoError(t, os.WriteFile(filepath.Join(inDir, "a.txt"), []byte("a\n"), 0o600))
+	r.NoError(t, os.WriteFile(templateB, []byte("{{uper(x)}}\n"), 0o600))
+	r.NoError(t, os.WriteFile(filepath.Join(outDir, "a.txt"), []byte("previous"), 0o600))
+	r.NoError(t, os.MkdirAll(filepath.Join(outDir, "static"), 0o700))
+	r.NoError(t, os.WriteFile(filepath.Join(outDir, "static", "unrelated.txt"), []byte("unrelated"), 0o640))
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	opts := &Options{
+		InPath:        inDir,
+		OutPath:       outDir,
+		NoStats:       true,
+		Transactional: true,
+		KeepGoing:     true,
+	}
+	ip, err := New(l, opts)
+	r.NoError(t, err)
+	r.ErrorIs(t, ip.Start(), ErrFilesFailed)
+
+	// A single failed file leaves the whole output directory unchanged.
+	b, err := os.ReadFile(filepath.Join(outDir, "a.txt"))
+	r.NoError(t, err)
+	r.Exactly(t, "previous", string(b))
+	entries, err := os.ReadDir(rootDir)
+	r.NoError(t, err)
+	r.Len(t, entries, 2)
+
+	// Files which are not rendered by the run are carried over into the new output directory.
+	r.NoError(t, os.WriteFile(templateB, []byte("{{upper(x)}}\n"), 0o600))
+	r.NoError(t, ip.Start())
+	b, err = os.ReadFile(filepath.Join(outDir, "a.txt"))
+	r.NoError(t, err)
+	r.Exactly(t, "a", string(b))
+	b, err = os.ReadFile(filepath.Join(outDir, "sub", "b.txt"))
+	r.NoError(t, err)
+	r.Exactly(t, "X", string(b))
+	b, err = os.ReadFile(filepath.Join(outDir, "static", "unrelated.txt"))
+	r.NoError(t, err)
+	r.Exactly(t, "unrelated", string(b))
+	stat, err := os.Stat(filepath.Join(outDir, "static", "unrelated.txt"))
+	r.NoError(t, err)
+	r.Exactly(t, os.FileMode(0o640), stat.Mode().Perm())
+	entries, err = os.ReadDir(rootDir)
+	r.NoError(t, err)
+	r.Len(t, entries, 2)
+
+	opts.Incremental = true
+	r.Error(t, ip.Start())
+}
+
+func TestStartStreamMode(t *testing.T) {
+	rootInDir := filepath.Join("testdata", "interpret", "in")
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	ip, err := New(l, &Options{
+		InPath:       "-",
+		OutPath:      "-",
+		VarFilePaths: []string{filepath.Join(rootInDir, "yatt.var")},
+		NoStats:      true,
+	})
+	r.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	ip.stdin = strings.NewReader("# yatt var name = stdin\n{{upper(name)}}\n# yatt import ./testdata/interpret/in/partials/nested2.yaml\n")
+	ip.stdout = out
+	r.NoError(t, ip.Start())
+
+	partial, err := os.ReadFile(filepath.Join(rootInDir, "partials", "nested2.yaml"))
+	r.NoError(t, err)
+	r.Exactly(t, "STDIN\n"+strings.TrimSuffix(string(partial), "\n"), out.String())
+}
+
+func TestStartSet(t *testing.T) {
+	dir := t.TempDir()
+	varFile := filepath.Join(dir, "vars.yaml")
+	r.NoError(t, os.WriteFile(varFile, []byte("env: dev\nregion: eu\n"), 0o600))
+	certFile := filepath.Join(dir, "cert.pem")
+	r.NoError(t, os.WriteFile(certFile, []byte("line 1\nline 2\n"), 0o600))
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	opts := &Options{
+		InPath:       "-",
+		OutPath:      "-",
+		VarFilePaths: []string{varFile},
+		Set:          []string{"env=prod", "url=a=b"},
+		SetFiles:     []string{"cert=" + certFile, "env=" + certFile},
+		NoStats:      true,
+	}
+	ip, err := New(l, opts)
+	r.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	ip.stdin = strings.NewReader("{{env}} {{region}} {{url}}\n{{cert}}\n")
+	ip.stdout = out
+	r.NoError(t, ip.Start())
+	// Set takes precedence over SetFiles, which takes precedence over the var files.
+	r.Exactly(t, "prod eu a=b\nline 1\nline 2", out.String())
+
+	opts.Set = []string{"=value"}
+	_, err = New(l, opts)
+	r.ErrorIs(t, err, ErrInvalidSet)
+	opts.Set = nil
+	opts.SetFiles = []string{"cert=" + filepath.Join(dir, "missing.pem")}
+	_, err = New(l, opts)
+	r.Error(t, err)
+}
+
+func TestStartCheckMode(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	outDir := filepath.Join(rootDir, "out")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+	r.NoError(t, os.MkdirAll(outDir, 0o700))
+
+	files := map[string]string{
+		filepath.Join(inDir, "same.txt"):    "# yatt var v = same\n{{v}}\n",
+		filepath.Join(inDir, "drift.txt"):   "# yatt var v = new\n{{v}}\n",
+		filepath.Join(inDir, "missing.txt"): "missing\n",
+		filepath.Join(outDir, "same.txt"):   "same",
+		filepath.Join(outDir, "drift.txt"):  "old",
+		filepath.Join(outDir, "extra.txt"):  "extra",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	ip, err := New(l, &Options{
+		InPath:  inDir,
+		OutPath: outDir,
+		Check:   true,
+		NoStats: true,
+	})
+	r.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	ip.stdout = out
+	err = ip.Start()
+	r.ErrorIs(t, err, errDrift)
+	r.Exactly(t, []string{filepath.Join(outDir, "drift.txt")}, ip.report.drifted)
+	r.Exactly(t, []string{filepath.Join(outDir, "missing.txt")}, ip.report.missing)
+	r.Exactly(t, []string{filepath.Join(outDir, "extra.txt")}, ip.report.extra)
+	r.Contains(t, out.String(), "-old\n")
+	r.Contains(t, out.String(), "+new\n")
+
+	// Nothing must have been written.
+	_, err = os.Stat(filepath.Join(outDir, "missing.txt"))
+	r.ErrorIs(t, err, os.ErrNotExist)
+	b, err := os.ReadFile(filepath.Join(outDir, "drift.txt"))
+	r.NoError(t, err)
+	r.Exactly(t, "old", string(b))
+
+	// Without any drift, the check succeeds.
+	r.NoError(t, os.Remove(filepath.Join(outDir, "extra.txt")))
+	r.NoError(t, os.Remove(filepath.Join(inDir, "missing.txt")))
+	r.NoError(t, os.WriteFile(filepath.Join(outDir, "drift.txt"), []byte("new"), 0o600))
+	r.NoError(t, ip.Start())
+}
+
+func TestStartSourceMap(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	outDir := filepath.Join(rootDir, "out")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+
+	files := map[string]string{
+		filepath.Join(inDir, "a.txt"):   "# yatt foreach 2\n{{index}}\n# yatt foreachend\n",
+		filepath.Join(inDir, "raw.bin"): "raw\n",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	opts := &Options{
+		InPath:        inDir,
+		OutPath:       outDir,
+		FileBlacklist: []string{`\.bin$`},
+		SourceMap:     true,
+		NoStats:       true,
+	}
+	ip, err := New(l, opts)
+	r.NoError(t, err)
+	r.NoError(t, ip.Start())
+
+	mp, err := sourcemap.Blame(filepath.Join(outDir, "a.txt") + ":2")
+	r.NoError(t, err)
+	r.Exactly(t, filepath.Join(inDir, "a.txt")+":2 (foreach iteration 1)", mp.String())
+
+	// Raw copies have no source map.
+	_, err = os.Stat(sourcemap.Path(filepath.Join(outDir, "raw.bin")))
+	r.ErrorIs(t, err, os.ErrNotExist)
+
+	// Source maps are replaced instead of being rewritten in place, a hard link keeps the previous map.
+	smPath := sourcemap.Path(filepath.Join(outDir, "a.txt"))
+	previous := filepath.Join(rootDir, "previous.yattmap")
+	r.NoError(t, os.Link(smPath, previous))
+	r.NoError(t, os.WriteFile(filepath.Join(inDir, "a.txt"), []byte("# yatt foreach 3\n{{index}}\n# yatt foreachend\n"), 0o600))
+	r.NoError(t, ip.Start())
+	prevMap, err := sourcemap.ReadFile(previous)
+	r.NoError(t, err)
+	r.Len(t, prevMap.Mappings, 2)
+	m, err := sourcemap.ReadFile(smPath)
+	r.NoError(t, err)
+	r.Len(t, m.Mappings, 3)
+	entries, err := os.ReadDir(outDir)
+	r.NoError(t, err)
+	r.Len(t, entries, 3)
+
+	// Source maps are no extra outputs in check mode.
+	opts.Check = true
+	ip, err = New(l, opts)
+	r.NoError(t, err)
+	r.NoError(t, ip.Start())
+}
+
+func TestStartStats(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	outDir := filepath.Join(rootDir, "out")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+
+	templateA := filepath.Join(inDir, "a.txt")
+	rawFile := filepath.Join(inDir, "raw.bin")
+	files := map[string]string{
+		templateA: "# yatt foreach 2\n{{upper(index)}}\n# yatt foreachend\n",
+		rawFile:   "raw\n",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	opts := &Options{
+		InPath:        inDir,
+		OutPath:       outDir,
+		FileBlacklist: []string{`\.bin$`},
+		StatsFormat:   StatsFormatJSON,
+	}
+	logs := &bytes.Buffer{}
+	ip, err := New(zerolog.New(logs), opts)
+	r.NoError(t, err)
+	stderr := &bytes.Buffer{}
+	ip.stderr = stderr
+	r.NoError(t, ip.Start())
+
+	// By default, only a single summary line is logged.
+	r.Empty(t, stderr.String())
+	r.Contains(t, logs.String(), `"message":"finished"`)
+	r.Exactly(t, 1, strings.Count(logs.String(), "\n"))
+
+	opts.Stats = true
+	opts.Incremental = true
+	ip, err = New(l, opts)
+	r.NoError(t, err)
+	ip.stderr = stderr
+	r.NoError(t, ip.Start())
+
+	var s runStats
+	r.NoError(t, json.Unmarshal(stderr.Bytes(), &s))
+	r.Exactly(t, 2, s.Files)
+	r.Exactly(t, 1, s.Interpreted)
+	r.Exactly(t, 1, s.Raw)
+	r.Exactly(t, int64(len(files[templateA])+len(files[rawFile])), s.BytesIn)
+	r.Exactly(t, int64(len("0\n1")+len(files[rawFile])), s.BytesOut)
+	r.Exactly(t, 2, s.LoopIterations)
+	r.Exactly(t, map[string]int{"upper": 2}, s.FunctionCalls)
+	r.Len(t, s.PerFile, 2)
+	r.Exactly(t, templateA, s.PerFile[0].Path)
+	r.Exactly(t, statsModeInterpreted, s.PerFile[0].Mode)
+	r.Exactly(t, rawFile, s.PerFile[1].Path)
+	r.Exactly(t, statsModeRaw, s.PerFile[1].Mode)
+
+	// Unchanged files are skipped by the second incremental run.
+	stderr.Reset()
+	r.NoError(t, ip.Start())
+	s = runStats{}
+	r.NoError(t, json.Unmarshal(stderr.Bytes(), &s))
+	r.Exactly(t, 2, s.Skipped)
+
+	// The text format prints a table with a trailing line of all function calls.
+	opts.StatsFormat = StatsFormatText
+	opts.Incremental = false
+	ip, err = New(l, opts)
+	r.NoError(t, err)
+	ip.stderr = stderr
+	stderr.Reset()
+	r.NoError(t, ip.Start())
+	r.Contains(t, stderr.String(), "total (1 interpreted, 1 raw, 0 skipped)")
+	r.True(t, strings.HasSuffix(stderr.String(), "function calls: upper=2\n"))
+
+	// Nothing is printed without stats.
+	opts.NoStats = true
+	stderr.Reset()
+	r.NoError(t, ip.Start())
+	r.Empty(t, stderr.String())
+
+	_, err = New(l, &Options{StatsFormat: "xml"})
+	r.ErrorIs(t, err, ErrInvalidStatsFormat)
+}
+
+func TestStartLineEnding(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	partialsDir := filepath.Join(rootDir, "partials")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+	r.NoError(t, os.MkdirAll(partialsDir, 0o700))
+
+	partial := filepath.Join(partialsDir, "partial.txt")
+	varFile := filepath.Join(rootDir, "yatt.var")
+	files := map[string]string{
+		partial:                          "p1\r\np2\r\n",
+		varFile:                          "# yatt var name = World\r\n",
+		filepath.Join(inDir, "lf.txt"):   "hello {{name}}\n# yatt foreach 2\n{{index}}\n# yatt foreachend\n# yatt import " + partial + "\nend\n",
+		filepath.Join(inDir, "crlf.txt"): "hello {{name}}\r\n# yatt foreach 2\r\n{{index}}\r\n# yatt foreachend\r\nend",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	type testCase struct {
+		lineEnding string
+		expected   map[string]string
+	}
+
+	testCases := []testCase{
+		{
+			lineEnding: LineEndingAuto,
+			expected: map[string]string{
+				"lf.txt":   "hello World\n0\n1\np1\np2\nend\n",
+				"crlf.txt": "hello World\r\n0\r\n1\r\nend",
+			},
+		},
+		{
+			lineEnding: LineEndingCRLF,
+			expected: map[string]string{
+				"lf.txt":   "hello World\r\n0\r\n1\r\np1\r\np2\r\nend",
+				"crlf.txt": "hello World\r\n0\r\n1\r\nend",
+			},
+		},
+		{
+			lineEnding: LineEndingLF,
+			expected: map[string]string{
+				"lf.txt":   "hello World\n0\n1\np1\np2\nend",
+				"crlf.txt": "hello World\n0\n1\nend",
+			},
+		},
+	}
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	for _, tc := range testCases {
+		outDir := filepath.Join(rootDir, "out-"+tc.lineEnding)
+		ip, err := New(l, &Options{
+			InPath:       inDir,
+			OutPath:      outDir,
+			VarFilePaths: []string{varFile},
+			LineEnding:   tc.lineEnding,
+			NoStats:      true,
+		})
+		r.NoError(t, err)
+		r.NoError(t, ip.Start())
+
+		for name, expected := range tc.expected {
+			b, err := os.ReadFile(filepath.Join(outDir, name))
+			r.NoError(t, err)
+			r.Exactly(t, expected, string(b), "lineEnding=%s, file=%s", tc.lineEnding, name)
+		}
+	}
+
+	_, err := New(l, &Options{LineEnding: "cr"})
+	r.ErrorIs(t, err, errInvalidLineEnding)
+}
+
+func TestDetectLineEnding(t *testing.T) {
+	type testCase struct {
+		content  string
+		le       string
+		trailing bool
+	}
+
+	// Place the line ending across the chunk boundary.
+	long := strings.Repeat("x", 32*1024-1)
+	testCases := []testCase{
+		{content: ""},
+		{content: "no line ending"},
+		{content: "lf\n", le: "\n", trailing: true},
+		{content: "crlf\r\nlf", le: "\r\n"},
+		{content: long + "\r\n", le: "\r\n", trailing: true},
+		{content: long + "x\r\n", le: "\r\n", trailing: true},
+		{content: long + "\n" + long + "\r\n", le: "\n", trailing: true},
+		{content: strings.Repeat(long, 100) + "\r\nend", le: "\r\n"},
+	}
+
+	for i, tc := range testCases {
+		le, trailing, err := detectLineEnding(strings.NewReader(tc.content), int64(len(tc.content)))
+		r.NoError(t, err, "case=%d", i)
+		r.Exactly(t, tc.le, string(le), "case=%d", i)
+		r.Exactly(t, tc.trailing, trailing, "case=%d", i)
+	}
+}
+
+func TestLint(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+
+	varFile := filepath.Join(rootDir, "yatt.var")
+	files := map[string]string{
+		varFile:                          "# yatt var name = World\n",
+		filepath.Join(inDir, "ok.txt"):   "hello {{name}}\n",
+		filepath.Join(inDir, "bad.txt"):  "# yatt foreach 2\n{{upper(nme)}} {{nme}}\n",
+		filepath.Join(inDir, "raw.json"): "{{ignored}}\n# yatt unknown\n",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	ip, err := New(l, &Options{
+		InPath:        inDir,
+		VarFilePaths:  []string{varFile},
+		FileBlacklist: []string{`\.json$`},
+		Diagnostics:   diagnostic.FormatJSON,
+	})
+	r.NoError(t, err)
+
+	out := &bytes.Buffer{}
+	ip.stderr = out
+	r.ErrorIs(t, ip.Lint(), ErrLint)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	r.Len(t, lines, 2)
+	bad := filepath.Join(inDir, "bad.txt")
+	r.JSONEq(t, `{"severity":"error","file":"`+bad+`","line":1,"column":1,"directive":"foreach","message":"unclosed foreach"}`, lines[0])
+	r.JSONEq(t, `{"severity":"error","file":"`+bad+`","line":2,"column":16,"message":"variable \"nme\" is never declared"}`, lines[1])
+
+	// Without any error, linting succeeds.
+	r.NoError(t, os.Remove(bad))
+	out.Reset()
+	r.NoError(t, ip.Lint())
+	r.Empty(t, out.String())
+}
+
+func TestDeps(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	partialsDir := filepath.Join(rootDir, "partials")
+	outDir := filepath.Join(rootDir, "out")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+	r.NoError(t, os.MkdirAll(partialsDir, 0o700))
+
+	one, two := filepath.Join(inDir, "one.txt"), filepath.Join(inDir, "two.txt")
+	a, b, shared := filepath.Join(partialsDir, "a.txt"), filepath.Join(partialsDir, "b.txt"), filepath.Join(partialsDir, "shared.txt")
+	files := map[string]string{
+		one:    "# yatt import " + a + "\n# yatt import " + b + "\n",
+		two:    "# yatt import " + b + "\n",
+		a:      "# yatt import " + shared + "\n",
+		b:      "# yatt import " + shared + "\n",
+		shared: "shared\n",
+	}
+	for path, content := range files {
+		r.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	}
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	ip, err := New(l, &Options{InPath: inDir, OutPath: outDir})
+	r.NoError(t, err)
+	out := &bytes.Buffer{}
+	ip.stdout = out
+
+	r.NoError(t, ip.Deps(DepsFormatTree, ""))
+	r.Exactly(t, one+" (output "+filepath.Join(outDir, "one.txt")+")\n"+
+		"├── "+a+"\n"+
+		"│   └── "+shared+"\n"+
+		"└── "+b+"\n"+
+		"    └── "+shared+"\n"+
+		two+" (output "+filepath.Join(outDir, "two.txt")+")\n"+
+		"└── "+b+"\n"+
+		"    └── "+shared+"\n", out.String())
+
+	out.Reset()
+	r.NoError(t, ip.Deps(DepsFormatDOT, ""))
+	r.Contains(t, out.String(), fmt.Sprintf("\t%q -> %q;\n", one, a))
+	r.Contains(t, out.String(), fmt.Sprintf("\t%q -> %q;\n", b, shared))
+	r.Contains(t, out.String(), fmt.Sprintf("\t%q -> %q [style=dashed];\n", one, filepath.Join(outDir, "one.txt")))
+
+	// The affected outputs are part of the reverse graph in every format.
+	out.Reset()
+	r.NoError(t, ip.Deps(DepsFormatTree, a))
+	r.Exactly(t, a+"\n"+
+		"└── "+one+" (output "+filepath.Join(outDir, "one.txt")+")\n", out.String())
+
+	out.Reset()
+	r.NoError(t, ip.Deps(DepsFormatDOT, a))
+	r.Contains(t, out.String(), fmt.Sprintf("\t%q -> %q;\n", one, a))
+	r.Contains(t, out.String(), fmt.Sprintf("\t%q [shape=box];\n", filepath.Join(outDir, "one.txt")))
+	r.Contains(t, out.String(), fmt.Sprintf("\t%q -> %q [style=dashed];\n", one, filepath.Join(outDir, "one.txt")))
+	r.NotContains(t, out.String(), filepath.Join(outDir, "two.txt"))
+
+	// The blast radius of the shared partial contains both templates.
+	out.Reset()
+	r.NoError(t, ip.Deps(DepsFormatJSON, shared))
+	g := depsGraph{}
+	r.NoError(t, json.Unmarshal(out.Bytes(), &g))
+	r.Exactly(t, []string{shared}, g.Roots)
+	r.Exactly(t, []string{a, b}, g.ImportedBy[shared])
+	r.Exactly(t, []string{one, two}, g.ImportedBy[b])
+	r.Exactly(t, map[string]string{
+		one: filepath.Join(outDir, "one.txt"),
+		two: filepath.Join(outDir, "two.txt"),
+	}, g.Outputs)
+
+	r.ErrorIs(t, ip.Deps(DepsFormatTree, filepath.Join(rootDir, "unknown.txt")), errNotInGraph)
+	r.ErrorIs(t, ip.Deps("svg", ""), ErrInvalidDepsFormat)
+}
+
+func TestStartIncremental(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	outDir := filepath.Join(rootDir, "out")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+
+	partial := filepath.Join(rootDir, "partial.txt")
+	templateA := filepath.Join(inDir, "a.txt")
+	templateB := filepath.Join(inDir, "b.txt")
+	r.NoError(t, os.WriteFile(partial, []byte("v1\n"), 0o600))
+	r.NoError(t, os.WriteFile(templateA, []byte("# yatt import "+partial+"\n"), 0o600))
+	r.NoError(t, os.WriteFile(templateB, []byte("static\n"), 0o600))
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	ip, err := New(l, &Options{
+		InPath:      inDir,
+		OutPath:     outDir,
+		Incremental: true,
+		SourceMap:   true,
+		NoStats:     true,
+	})
+	r.NoError(t, err)
+	r.NoError(t, ip.Start())
+	_, err = os.Stat(filepath.Join(outDir, manifestName))
+	r.NoError(t, err)
+
+	// Move the modification times into the past to detect rewrites.
+	outA := filepath.Join(outDir, "a.txt")
+	outB := filepath.Join(outDir, "b.txt")
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, path := range []string{outA, outB, sourcemap.Path(outA), sourcemap.Path(outB)} {
+		r.NoError(t, os.Chtimes(path, past, past))
+	}
+	modTime := func(path string) time.Time {
+		stat, err := os.Stat(path)
+		r.NoError(t, err)
+		return stat.ModTime()
+	}
+
+	// Changing the partial only re-renders the importing template.
+	r.NoError(t, os.WriteFile(partial, []byte("v2\n"), 0o600))
+	r.NoError(t, ip.Start())
+	b, err := os.ReadFile(outA)
+	r.NoError(t, err)
+	r.Exactly(t, "v2", string(b))
+	r.True(t, modTime(outA).After(past))
+	r.Exactly(t, past, modTime(outB))
+	// The lines still originate from the same template lines, the source map is not rewritten.
+	r.Exactly(t, past, modTime(sourcemap.Path(outA)))
+	r.Exactly(t, past, modTime(sourcemap.Path(outB)))
+
+	// Changed templates with an identical output are not written.
+	r.NoError(t, os.WriteFile(templateB, []byte("# yatt ignore\ncomment\n# yatt ignoreend\nstatic\n"), 0o600))
+	r.NoError(t, ip.Start())
+	r.Exactly(t, past, modTime(outB))
+
+	// Modified outputs are rendered again.
+	r.NoError(t, os.WriteFile(outB, []byte("modified"), 0o600))
+	r.NoError(t, ip.Start())
+	b, err = os.ReadFile(outB)
+	r.NoError(t, err)
+	r.Exactly(t, "static", string(b))
+
+	// Removed templates are dropped from the manifest.
+	r.NoError(t, os.Remove(templateB))
 	r.NoError(t, ip.Start())
+	m, err := loadManifest(filepath.Join(outDir, manifestName))
+	r.NoError(t, err)
+	r.Contains(t, m.Outputs, outA)
+	r.NotContains(t, m.Outputs, outB)
+}
+
+func TestStartIncrementalInPlace(t *testing.T) {
+	dir := t.TempDir()
+	r.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("# yatt var x = 1\n{{x}}\n"), 0o600))
+
+	// The manifest and source maps are written into the input path and must not be rendered as templates.
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	for range 2 {
+		ip, err := New(l, &Options{
+			InPath:      dir,
+			OutPath:     dir,
+			Incremental: true,
+			SourceMap:   true,
+			NoStats:     true,
+		})
+		r.NoError(t, err)
+		r.NoError(t, ip.Start())
+	}
+
+	entries, err := os.ReadDir(dir)
+	r.NoError(t, err)
+	names := make([]string, len(entries))
+	for j, e := range entries {
+		names[j] = e.Name()
+	}
+	r.Exactly(t, []string{manifestName, "a.txt", "a.txt" + sourcemap.Extension}, names)
+}
+
+func TestWatch(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	outDir := filepath.Join(rootDir, "out")
+	partialDir := filepath.Join(rootDir, "partials")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+	r.NoError(t, os.MkdirAll(partialDir, 0o700))
+
+	partial := filepath.Join(partialDir, "partial.txt")
+	r.NoError(t, os.WriteFile(partial, []byte("v1\n"), 0o600))
+	r.NoError(t, os.WriteFile(filepath.Join(inDir, "a.txt"), []byte("# yatt import "+partial+"\n"), 0o600))
+	r.NoError(t, os.WriteFile(filepath.Join(inDir, "b.txt"), []byte("static\n"), 0o600))
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	ip, err := New(l, &Options{
+		InPath:  inDir,
+		OutPath: outDir,
+		NoStats: true,
+	})
+	r.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ip.Watch(ctx)
+	}()
+	defer func() {
+		cancel()
+		r.NoError(t, <-done)
+	}()
+
+	outA := filepath.Join(outDir, "a.txt")
+	outB := filepath.Join(outDir, "b.txt")
+	awaitContent := func(path, expected string) {
+		r.Eventually(t, func() bool {
+			b, err := os.ReadFile(path)
+			return err == nil && string(b) == expected
+		}, 5*time.Second, 20*time.Millisecond, "path=%s", path)
+	}
+	awaitContent(outA, "v1")
+	awaitContent(outB, "static")
+
+	// Changing the partial must only re-render the importing template.
+	r.NoError(t, os.Remove(outB))
+	r.NoError(t, os.WriteFile(partial, []byte("v2\n"), 0o600))
+	awaitContent(outA, "v2")
+	_, err = os.Stat(outB)
+	r.ErrorIs(t, err, os.ErrNotExist)
+
+	// Removed inputs remove their outputs.
+	r.NoError(t, os.Remove(filepath.Join(inDir, "a.txt")))
+	r.Eventually(t, func() bool {
+		_, err := os.Stat(outA)
+		return os.IsNotExist(err)
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestWatchRejectsModes(t *testing.T) {
+	rootDir := t.TempDir()
+	inDir := filepath.Join(rootDir, "in")
+	r.NoError(t, os.MkdirAll(inDir, 0o700))
+	r.NoError(t, os.WriteFile(filepath.Join(inDir, "a.txt"), []byte("a\n"), 0o600))
+
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	for i, opts := range []*Options{
+		{Check: true},
+		{Incremental: true},
+		{Transactional: true},
+	} {
+		opts.InPath = inDir
+		opts.OutPath = filepath.Join(rootDir, "out")
+		opts.NoStats = true
+		ip, err := New(l, opts)
+		r.NoError(t, err, "case=%d", i)
+		r.ErrorIs(t, ip.Watch(context.Background()), errWatchMode, "case=%d", i)
+	}
 }
 
 //
@@ -93,11 +940,12 @@ func TestStartDirMode(t *testing.T) {
 func BenchmarkFileInterpretation(b *testing.B) {
 	rootDir := filepath.Join("testdata", "interpret", "in")
 	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	ip := New(l, &Options{
+	ip, err := New(l, &Options{
 		VarFilePaths: []string{filepath.Join(rootDir, "yatt.var")},
 		Indent:       true,
 		NoStats:      true,
 	})
+	r.NoError(b, err)
 
 	// file gets closed by the Interpret call.
 	path := filepath.Join(rootDir, "rootfile.yaml")
@@ -120,13 +968,14 @@ func BenchmarkFileInterpretation(b *testing.B) {
 func BenchmarkFileWrites(b *testing.B) {
 	var testDir = filepath.Join("testdata", "interpret")
 	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-	ip := New(l, &Options{
+	ip, err := New(l, &Options{
 		InPath:       filepath.Join(testDir, "in", "rootfile.yaml"),
 		OutPath:      filepath.Join(testDir, "out", "rootfile.yaml"),
 		VarFilePaths: []string{filepath.Join(testDir, "in", "yatt.var")},
 		Indent:       true,
 		NoStats:      true,
 	})
+	r.NoError(b, err)
 	r.NoError(b, os.MkdirAll(testDir, 0700))
 
 	b.ResetTimer()
@@ -0,0 +1,117 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+
+	"github.com/xiroxasx/yatt/internal/diagnostic"
+)
+
+// ErrLint is returned by Lint if any error has been found.
+var ErrLint = errors.New("lint failed")
+
+// Lint checks the var files and all templates of the input path, including their imports, without rendering them.
+// Diagnostics are written to stderr in the configured format.
+func (i *Interpreter) Lint() (err error) {
+	ds := make([]*diagnostic.Diagnostic, 0)
+	for _, vf := range i.opts.VarFilePaths {
+		vds, lErr := i.core.LintVarFile(vf)
+		if lErr != nil {
+			return fmt.Errorf("unable to lint var file %s: %v", vf, lErr)
+		}
+		ds = append(ds, vds...)
+	}
+
+	inPaths, err := i.lintInputs()
+	if err != nil {
+		return
+	}
+	for _, in := range inPaths {
+		var fds []*diagnostic.Diagnostic
+		if in == stdioPath {
+			fds, err = i.core.Lint(stdioPath, i.stdin)
+		} else {
+			fds, err = i.core.LintFile(in)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to lint %s: %v", in, err)
+		}
+		ds = append(ds, fds...)
+	}
+
+	ds = uniqueDiagnostics(ds)
+	err = diagnostic.Write(i.stderr, i.opts.Diagnostics, ds...)
+	if err != nil {
+		return
+	}
+
+	errCount := 0
+	for _, d := range ds {
+		if d.Severity == diagnostic.SeverityError {
+			errCount++
+		}
+	}
+	if errCount > 0 {
+		return fmt.Errorf("%w: %d errors", ErrLint, errCount)
+	}
+	return
+}
+
+// lintInputs returns the templates of the input path, files which are copied without interpretation are skipped.
+func (i *Interpreter) lintInputs() (inPaths []string, err error) {
+	inPath := filepath.Clean(i.opts.InPath)
+	if inPath == stdioPath {
+		return []string{stdioPath}, nil
+	}
+
+	err = filepath.WalkDir(inPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || isSidecar(entry.Name()) || i.isRawCopy(path) {
+			return nil
+		}
+		inPaths = append(inPaths, path)
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unable to stat input path: %v", err)
+	}
+	return
+}
+
+// uniqueDiagnostics removes the diagnostics of imports which are shared by multiple templates.
+// The remaining diagnostics are sorted by their location.
+func uniqueDiagnostics(ds []*diagnostic.Diagnostic) (unique []*diagnostic.Diagnostic) {
+	type location struct {
+		file    string
+		line    int
+		column  int
+		message string
+	}
+
+	seen := make(map[location]struct{})
+	for _, d := range ds {
+		loc := location{d.File, d.Line, d.Column, d.Message}
+		_, ok := seen[loc]
+		if ok {
+			continue
+		}
+		seen[loc] = struct{}{}
+		unique = append(unique, d)
+	}
+
+	sort.SliceStable(unique, func(a, b int) bool {
+		if unique[a].File != unique[b].File {
+			return unique[a].File < unique[b].File
+		}
+		if unique[a].Line != unique[b].Line {
+			return unique[a].Line < unique[b].Line
+		}
+		return unique[a].Column < unique[b].Column
+	})
+	return
+}
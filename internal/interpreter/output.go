@@ -0,0 +1,205 @@
+package interpreter
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// output is an opened output.
+// Its content is committed by Close or discarded by Abort.
+type output interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// atomicFile is written to a temporary file inside the directory of its path,
+// which replaces the file at path on Close.
+// This way, failed renders and crashes never leave a truncated output behind.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+// createAtomicFile creates a temporary file for the output at path.
+// Existing outputs keep their permissions, new ones are created with perm.
+func createAtomicFile(path string, perm os.FileMode) (f *atomicFile, err error) {
+	stat, err := os.Stat(path)
+	if err == nil {
+		perm = stat.Mode().Perm()
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return
+	}
+	f = &atomicFile{File: tmp, path: path}
+	err = tmp.Chmod(perm)
+	if err != nil {
+		_ = f.Abort()
+		return nil, err
+	}
+	return
+}
+
+// Close flushes the temporary file and renames it to the output path.
+func (f *atomicFile) Close() (err error) {
+	err = f.File.Sync()
+	if err != nil {
+		_ = f.Abort()
+		return
+	}
+	err = f.File.Close()
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return
+	}
+
+	err = os.Rename(f.Name(), f.path)
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return
+}
+
+// Abort removes the temporary file, the output at path is left unchanged.
+func (f *atomicFile) Abort() error {
+	// The file may already be closed, only the removal matters.
+	_ = f.File.Close()
+	return os.Remove(f.Name())
+}
+
+// runDirModeTransactional renders the dir mode into a staging directory next to outPath.
+// The staging directory starts as a copy of outPath, so that files which are not rendered by the run are kept.
+// Only if every file has been rendered successfully, the staging directory replaces outPath as a whole.
+// Otherwise, outPath is left unchanged.
+func (i *Interpreter) runDirModeTransactional(sourcePath, outPath string) (err error) {
+	parent := filepath.Dir(outPath)
+	err = os.MkdirAll(parent, 0o755)
+	if err != nil {
+		return
+	}
+
+	staging, err := os.MkdirTemp(parent, "."+filepath.Base(outPath)+".staging-*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		rmErr := os.RemoveAll(staging)
+		if rmErr != nil {
+			i.l.Err(rmErr).Str("path", staging).Msg("unable to remove staging directory")
+		}
+	}()
+
+	// Keep the permissions of an existing output directory.
+	perm := os.FileMode(0o755)
+	stat, sErr := os.Stat(outPath)
+	if sErr == nil {
+		perm = stat.Mode().Perm()
+	}
+	err = os.Chmod(staging, perm)
+	if err != nil {
+		return
+	}
+	if sErr == nil {
+		err = copyDir(outPath, staging)
+		if err != nil {
+			return
+		}
+	}
+
+	err = i.runDirMode(sourcePath, staging)
+	if err != nil {
+		return
+	}
+	return swapDir(staging, outPath)
+}
+
+// swapDir replaces the directory at path with staging.
+// The previous directory is restored if staging cannot be moved into place.
+func swapDir(staging, path string) (err error) {
+	previous := ""
+	_, err = os.Stat(path)
+	if err == nil {
+		previous = staging + ".previous"
+		err = os.Rename(path, previous)
+		if err != nil {
+			return
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+
+	err = os.Rename(staging, path)
+	if err != nil {
+		if previous != "" {
+			_ = os.Rename(previous, path)
+		}
+		return
+	}
+
+	if previous == "" {
+		return
+	}
+	return os.RemoveAll(previous)
+}
+
+// copyDir copies the files, directories and symlinks of src into dst, keeping their permissions.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		// Sockets, pipes and devices are no outputs.
+		return nil
+	})
+}
+
+// copyFile copies the regular file src to dst, which is created with perm.
+func copyFile(src, dst string, perm os.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(out, in)
+	cErr := out.Close()
+	if err == nil {
+		err = cErr
+	}
+	return
+}
@@ -0,0 +1,133 @@
+package interpreter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/xiroxasx/yatt/internal/core"
+)
+
+// Formats of the statistics printed after a run.
+const (
+	StatsFormatText = "text"
+	StatsFormatJSON = "json"
+)
+
+var ErrInvalidStatsFormat = fmt.Errorf("stats format must be either %s or %s", StatsFormatText, StatsFormatJSON)
+
+// Modes of a file in the statistics.
+const (
+	statsModeInterpreted = "interpreted"
+	statsModeRaw         = "raw"
+	statsModeSkipped     = "skipped"
+)
+
+// fileStats are the statistics of a single template.
+type fileStats struct {
+	Path string `json:"path"`
+	// Mode is one of statsModeInterpreted, statsModeRaw or statsModeSkipped.
+	Mode     string        `json:"mode"`
+	Duration time.Duration `json:"durationNs"`
+	BytesIn  int64         `json:"bytesIn"`
+	BytesOut int64         `json:"bytesOut"`
+	core.Stats
+}
+
+// runStats are the statistics of a whole run, collected concurrently by the workers of the dir mode.
+type runStats struct {
+	Duration    time.Duration `json:"durationNs"`
+	Files       int           `json:"files"`
+	Interpreted int           `json:"interpreted"`
+	Raw         int           `json:"raw"`
+	Skipped     int           `json:"skipped"`
+	BytesIn     int64         `json:"bytesIn"`
+	BytesOut    int64         `json:"bytesOut"`
+	core.Stats
+	PerFile []fileStats `json:"perFile"`
+
+	mx *sync.Mutex
+}
+
+func newRunStats() *runStats {
+	return &runStats{
+		PerFile: make([]fileStats, 0),
+		mx:      &sync.Mutex{},
+	}
+}
+
+// add records the statistics of a single file.
+// Calls on a nil runStats are ignored, e.g. in watch mode.
+func (s *runStats) add(fs fileStats) {
+	if s == nil {
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.Files++
+	switch fs.Mode {
+	case statsModeRaw:
+		s.Raw++
+	case statsModeSkipped:
+		s.Skipped++
+	default:
+		s.Interpreted++
+	}
+	s.BytesIn += fs.BytesIn
+	s.BytesOut += fs.BytesOut
+	s.Stats.Add(fs.Stats)
+	s.PerFile = append(s.PerFile, fs)
+}
+
+// write writes the statistics in the given format to w.
+// Files are sorted by their path, regardless of the order they have been rendered in.
+func (s *runStats) write(w io.Writer, format string) (err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	sort.Slice(s.PerFile, func(a, b int) bool {
+		return s.PerFile[a].Path < s.PerFile[b].Path
+	})
+
+	if format == StatsFormatJSON {
+		return json.NewEncoder(w).Encode(s)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tMODE\tDURATION\tBYTES IN\tBYTES OUT\tIMPORTS\tDEPTH\tITERATIONS\tCALLS")
+	for _, fs := range s.PerFile {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			fs.Path, fs.Mode, fs.Duration, fs.BytesIn, fs.BytesOut,
+			fs.Imports, fs.ImportDepth, fs.LoopIterations, sumCalls(fs.FunctionCalls))
+	}
+	fmt.Fprintf(tw, "total (%d interpreted, %d raw, %d skipped)\t\t%s\t%d\t%d\t%d\t%d\t%d\t%d\n",
+		s.Interpreted, s.Raw, s.Skipped, s.Duration, s.BytesIn, s.BytesOut,
+		s.Imports, s.ImportDepth, s.LoopIterations, sumCalls(s.FunctionCalls))
+	err = tw.Flush()
+	if err != nil || len(s.FunctionCalls) == 0 {
+		return
+	}
+
+	calls := make([]string, 0, len(s.FunctionCalls))
+	for _, name := range slices.Sorted(maps.Keys(s.FunctionCalls)) {
+		calls = append(calls, fmt.Sprintf("%s=%d", name, s.FunctionCalls[name]))
+	}
+	_, err = fmt.Fprintf(w, "function calls: %s\n", strings.Join(calls, ", "))
+	return
+}
+
+func sumCalls(calls map[string]int) (n int) {
+	for _, c := range calls {
+		n += c
+	}
+	return
+}
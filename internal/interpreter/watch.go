@@ -0,0 +1,255 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/xiroxasx/yatt/internal/core"
+)
+
+var errWatchMode = errors.New("watch mode cannot be combined with check, incremental or transactional mode")
+
+// watchDebounce is the time to wait for further events before re-rendering.
+// Editors tend to emit multiple events for a single save.
+const watchDebounce = 100 * time.Millisecond
+
+type pathSet map[string]struct{}
+
+func (s pathSet) has(p string) bool {
+	_, ok := s[p]
+	return ok
+}
+
+// Watch renders the input once and afterwards re-renders every output whose import chain
+// contains a changed file until ctx is done.
+// Render errors are logged and do not stop the watcher.
+func (i *Interpreter) Watch(ctx context.Context) (err error) {
+	i.opts.InPath = filepath.Clean(i.opts.InPath)
+	i.opts.OutPath = filepath.Clean(i.opts.OutPath)
+	if i.opts.InPath == stdioPath || i.opts.OutPath == stdioPath {
+		return errors.New("watch mode does not support stdin or stdout")
+	}
+	if i.opts.InPath == i.opts.OutPath {
+		return errors.New("watch mode requires an output path which differs from the input path")
+	}
+	if i.opts.Check || i.opts.Incremental || i.opts.Transactional {
+		return errWatchMode
+	}
+
+	stat, err := os.Stat(i.opts.InPath)
+	if err != nil {
+		return fmt.Errorf("unable to stat input path: %v", err)
+	}
+	isDir := stat.IsDir()
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return
+	}
+	defer func() {
+		cErr := w.Close()
+		if err == nil {
+			err = cErr
+		}
+	}()
+
+	inputs, err := i.watchRender(w, isDir, nil, nil)
+	if err != nil {
+		return
+	}
+	i.l.Info().Str("path", i.opts.InPath).Msg("watching for changes")
+
+	var (
+		changed  = make(pathSet)
+		debounce = time.NewTimer(watchDebounce)
+	)
+	debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			name := filepath.Clean(ev.Name)
+			if ev.Op == fsnotify.Chmod || i.isOutput(name) {
+				continue
+			}
+			changed[name] = struct{}{}
+			debounce.Reset(watchDebounce)
+
+		case wErr, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			i.l.Err(wErr).Msg("watcher error")
+
+		case <-debounce.C:
+			next, rErr := i.watchRender(w, isDir, inputs, changed)
+			if rErr != nil {
+				i.l.Err(rErr).Str("path", i.opts.InPath).Msg("unable to render")
+				continue
+			}
+			inputs = next
+			changed = make(pathSet)
+		}
+	}
+}
+
+// watchRender re-renders all inputs affected by the changed paths.
+// If changed is nil, every input gets rendered.
+// Outputs of inputs which no longer exist are removed.
+// The returned set contains the inputs which are currently known.
+func (i *Interpreter) watchRender(w *fsnotify.Watcher, isDir bool, prevInputs, changed pathSet) (inputs pathSet, err error) {
+	inputs, dirs, err := i.watchInputs(isDir)
+	if err != nil {
+		return
+	}
+
+	for in := range prevInputs {
+		if inputs.has(in) {
+			continue
+		}
+
+		dest := i.watchOutPath(isDir, in)
+		rmErr := os.Remove(dest)
+		if rmErr != nil && !errors.Is(rmErr, fs.ErrNotExist) {
+			i.l.Err(rmErr).Str("file", dest).Msg("unable to remove output")
+			continue
+		}
+		i.l.Info().Str("file", dest).Msg("removed output")
+	}
+
+	// Start off with a fresh core so that changed var files and stale states are not carried over.
+	i.core, err = i.newCore()
+	if err != nil {
+		return
+	}
+
+	varsChanged := false
+	varFiles := i.setFilePaths()
+	for _, vf := range i.opts.VarFilePaths {
+		varFiles = append(varFiles, core.VarFilePath(vf))
+	}
+	for _, vf := range varFiles {
+		if changed.has(vf) {
+			varsChanged = true
+			break
+		}
+	}
+
+	watched := dirs
+	for _, vf := range varFiles {
+		watched[filepath.Dir(vf)] = struct{}{}
+	}
+
+	for in := range inputs {
+		watched[filepath.Dir(in)] = struct{}{}
+
+		// The dependency check records the import chain of the input.
+		c := i.core.Fork()
+		depErr := c.ImportPathCheckCyclicDependencies(in)
+		deps := c.Dependencies(in)
+		for _, dep := range deps {
+			watched[filepath.Dir(dep)] = struct{}{}
+		}
+		if depErr != nil {
+			i.logError(depErr, in, "dependency check")
+			continue
+		}
+
+		if !i.isAffected(in, deps, changed, varsChanged) {
+			continue
+		}
+
+		dest := i.watchOutPath(isDir, in)
+		rErr := os.MkdirAll(filepath.Dir(dest), 0o755)
+		if rErr == nil {
+			rErr = i.writeInterpretedFile(c, in, dest)
+		}
+		if rErr != nil {
+			i.logError(rErr, in, "render failed")
+			continue
+		}
+		i.l.Info().Str("file", dest).Msg("rendered")
+	}
+
+	for dir := range watched {
+		aErr := w.Add(dir)
+		if aErr != nil {
+			i.l.Err(aErr).Str("path", dir).Msg("unable to watch directory")
+		}
+	}
+	return
+}
+
+// isAffected reports whether the input needs to be rendered again.
+func (i *Interpreter) isAffected(in string, deps []string, changed pathSet, varsChanged bool) bool {
+	if changed == nil || varsChanged || changed.has(in) {
+		return true
+	}
+	for _, dep := range deps {
+		if changed.has(dep) {
+			return true
+		}
+	}
+	return false
+}
+
+// watchInputs lists all templates which need to be rendered.
+// Directories of the input tree are returned separately,
+// so that newly created files get noticed.
+func (i *Interpreter) watchInputs(isDir bool) (inputs, dirs pathSet, err error) {
+	inputs = make(pathSet)
+	dirs = make(pathSet)
+	if !isDir {
+		_, err = os.Stat(i.opts.InPath)
+		if err == nil {
+			inputs[i.opts.InPath] = struct{}{}
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+
+	err = filepath.WalkDir(i.opts.InPath, func(inPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			dirs[inPath] = struct{}{}
+			return nil
+		}
+		if isSidecar(entry.Name()) {
+			return nil
+		}
+		inputs[inPath] = struct{}{}
+		return nil
+	})
+	return
+}
+
+// isOutput reports whether the path lies inside the output path.
+// Events for written outputs must not trigger another render.
+func (i *Interpreter) isOutput(path string) bool {
+	rel, err := filepath.Rel(i.opts.OutPath, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// watchOutPath returns the output path of the given input path.
+func (i *Interpreter) watchOutPath(isDir bool, inPath string) string {
+	if !isDir {
+		return i.opts.OutPath
+	}
+	return strings.ReplaceAll(inPath, i.opts.InPath, i.opts.OutPath)
+}
@@ -0,0 +1,148 @@
+// Package sourcemap maps the lines of rendered outputs back to the template lines which produced them.
+package sourcemap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Extension is appended to the path of an output to get the path of its source map.
+	Extension = ".yattmap"
+	Version   = 1
+)
+
+var (
+	ErrNoMapping  = errors.New("no mapping for line")
+	ErrInvalidRef = errors.New("reference must be <output path>:<line>")
+)
+
+// Frame is a line of a template.
+// Lines start at 1.
+type Frame struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// Iteration is the index of the innermost foreach iteration which produced the line.
+	Iteration *int `json:"iteration,omitempty"`
+	// Branch is the line of the if, elseif or else directive whose branch contains the line.
+	Branch int `json:"branch,omitempty"`
+}
+
+// Mapping is the origin of a single output line.
+type Mapping struct {
+	// Output is the line of the output, starting at 1.
+	Output int `json:"output"`
+	Frame
+	// ImportStack contains the import statements which led to File, innermost first.
+	ImportStack []Frame `json:"importStack,omitempty"`
+}
+
+// Map contains the mappings of every line of an output, in order.
+type Map struct {
+	Version  int       `json:"version"`
+	Mappings []Mapping `json:"mappings"`
+}
+
+func New() *Map {
+	return &Map{
+		Version:  Version,
+		Mappings: make([]Mapping, 0),
+	}
+}
+
+// Path returns the path of the source map of the output at outPath.
+func Path(outPath string) string {
+	return outPath + Extension
+}
+
+// Add maps the next lines output lines to source.
+// A single template line may produce multiple output lines, e.g. by multiline variables.
+func (m *Map) Add(source Frame, importStack []Frame, lines int) {
+	for range lines {
+		m.Mappings = append(m.Mappings, Mapping{
+			Output:      len(m.Mappings) + 1,
+			Frame:       source,
+			ImportStack: append([]Frame(nil), importStack...),
+		})
+	}
+}
+
+// Lookup returns the mapping of the given output line.
+func (m *Map) Lookup(line int) (mp Mapping, ok bool) {
+	if line < 1 || line > len(m.Mappings) {
+		return
+	}
+	return m.Mappings[line-1], true
+}
+
+// Write writes the source map to w.
+func (m *Map) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(m)
+}
+
+// ReadFile reads the source map at path.
+func ReadFile(path string) (m *Map, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	m = &Map{}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse source map %s: %v", path, err)
+	}
+	if m.Version != Version {
+		return nil, fmt.Errorf("unsupported source map version %d, render %s again", m.Version, path)
+	}
+	return
+}
+
+// Blame returns the mapping of the output line referenced by ref, e.g. "out.conf:123".
+func Blame(ref string) (mp Mapping, err error) {
+	idx := strings.LastIndex(ref, ":")
+	if idx < 1 {
+		return mp, ErrInvalidRef
+	}
+	line, err := strconv.Atoi(ref[idx+1:])
+	if err != nil {
+		return mp, ErrInvalidRef
+	}
+
+	m, err := ReadFile(Path(ref[:idx]))
+	if err != nil {
+		return
+	}
+
+	mp, ok := m.Lookup(line)
+	if !ok {
+		return mp, fmt.Errorf("%w %d, %s has %d lines", ErrNoMapping, line, ref[:idx], len(m.Mappings))
+	}
+	return
+}
+
+func (f Frame) String() string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "%s:%d", f.File, f.Line)
+	if f.Iteration != nil {
+		fmt.Fprintf(&sb, " (foreach iteration %d)", *f.Iteration)
+	}
+	if f.Branch > 0 {
+		fmt.Fprintf(&sb, " (branch in line %d)", f.Branch)
+	}
+	return sb.String()
+}
+
+func (mp Mapping) String() string {
+	sb := strings.Builder{}
+	sb.WriteString(mp.Frame.String())
+	for _, f := range mp.ImportStack {
+		fmt.Fprintf(&sb, "\n\timported by %s", f)
+	}
+	return sb.String()
+}
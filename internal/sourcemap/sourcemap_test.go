@@ -0,0 +1,51 @@
+package sourcemap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	r "github.com/stretchr/testify/require"
+)
+
+func TestAddAndLookup(t *testing.T) {
+	t.Parallel()
+
+	iteration := 2
+	m := New()
+	m.Add(Frame{File: "main.txt", Line: 1}, nil, 1)
+	m.Add(Frame{File: "partial.txt", Line: 4, Iteration: &iteration}, []Frame{{File: "main.txt", Line: 2}}, 2)
+
+	mp, ok := m.Lookup(3)
+	r.True(t, ok)
+	r.Exactly(t, 3, mp.Output)
+	r.Exactly(t, "partial.txt:4 (foreach iteration 2)\n\timported by main.txt:2", mp.String())
+
+	_, ok = m.Lookup(0)
+	r.False(t, ok)
+	_, ok = m.Lookup(4)
+	r.False(t, ok)
+}
+
+func TestBlame(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "out.conf")
+	m := New()
+	m.Add(Frame{File: "main.txt", Line: 1, Branch: 3}, nil, 2)
+	f, err := os.Create(Path(out))
+	r.NoError(t, err)
+	r.NoError(t, m.Write(f))
+	r.NoError(t, f.Close())
+
+	mp, err := Blame(out + ":2")
+	r.NoError(t, err)
+	r.Exactly(t, "main.txt:1 (branch in line 3)", mp.String())
+
+	_, err = Blame(out + ":3")
+	r.ErrorIs(t, err, ErrNoMapping)
+	_, err = Blame(out)
+	r.ErrorIs(t, err, ErrInvalidRef)
+	_, err = Blame(out + ":x")
+	r.ErrorIs(t, err, ErrInvalidRef)
+}
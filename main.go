@@ -3,15 +3,35 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/xiroxasx/yatt/internal/config"
+	"github.com/xiroxasx/yatt/internal/diagnostic"
 	"github.com/xiroxasx/yatt/internal/interpreter"
+	"github.com/xiroxasx/yatt/internal/sourcemap"
+)
+
+// stdioPath can be passed as in or out path to read from stdin or write to stdout.
+const stdioPath = "-"
+
+const (
+	// commandLint is passed as first argument to lint the templates instead of rendering them.
+	commandLint = "lint"
+	// commandDeps is passed as first argument to print the import graph instead of rendering the templates.
+	commandDeps = "deps"
+	// commandBlame is passed as first argument to look up the template line of an output line.
+	commandBlame = "blame"
 )
 
 type MultiString []string
@@ -25,36 +45,130 @@ func (vp *MultiString) Set(v string) (err error) {
 	return
 }
 
-func parseFlags() (a interpreter.Options) {
+type cliArgs struct {
+	// command is the optional subcommand, e.g. commandLint.
+	command    string
+	opts       interpreter.Options
+	configPath string
+	targets    MultiString
+	// depsFormat and reverse configure the output of commandDeps.
+	depsFormat string
+	reverse    string
+	// explicit contains the names of all flags which have been set on the command line.
+	explicit map[string]bool
+}
+
+func parseFlags() (a cliArgs) {
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == commandLint || args[0] == commandDeps) {
+		a.command = args[0]
+		args = args[1:]
+	}
+
 	fileBlackList := make(MultiString, 0)
 	fileWhiteList := make(MultiString, 0)
 	varFilePaths := make(MultiString, 0)
+	sets := make(MultiString, 0)
+	setFiles := make(MultiString, 0)
+	prefixes := make(MultiString, 0)
+	crlf := false
 
-	flag.BoolVar(&a.Indent, "indent", false, "whether to retain indention or not")
+	flag.BoolVar(&a.opts.Indent, "indent", false, "whether to retain indention or not")
+	flag.BoolVar(&a.opts.Strict, "strict", false, "fail on variables which cannot be resolved instead of rendering them empty")
+	flag.BoolVar(&a.opts.SourceMap, "source-map", false, "write a source map next to every output, which maps the output lines to their template lines")
+	flag.BoolVar(&a.opts.Passthrough, "passthrough", false, "keep tokens which are neither a known variable nor function verbatim, e.g. Helm expressions")
 	flag.Var(&fileBlackList, "blacklist", "regex to describe which files should not be interpreted")
 	flag.Var(&fileWhiteList, "whitelist", "regex to describe which files should be interpreted")
-	flag.BoolVar(&a.NoStats, "no-stats", false, "do not print stats at the end of the execution")
-	flag.BoolVar(&a.Verbose, "verbose", false, "print verbosely")
-	flag.StringVar(&a.InPath, "in", "", "the root path")
-	flag.StringVar(&a.OutPath, "out", "", "the output path. If not used, in will be overwritten")
-	flag.Var(&varFilePaths, "var", "the optional var file path.")
-	flag.Parse()
-
-	a.FileBlacklist = fileBlackList
-	a.FileWhitelist = fileWhiteList
-	a.VarFilePaths = varFilePaths
+	flag.BoolVar(&a.opts.NoStats, "no-stats", false, "do not print stats at the end of the execution")
+	flag.BoolVar(&a.opts.Stats, "stats", false, "print the stats of every rendered file instead of a single summary line")
+	flag.StringVar(&a.opts.StatsFormat, "stats-format", interpreter.StatsFormatText, "the format of the stats printed to stderr with -stats, either text or json")
+	flag.BoolVar(&a.opts.Verbose, "verbose", false, "print verbosely")
+	flag.BoolVar(&a.opts.Trace, "trace", false, "log every evaluation step: taken condition branches, foreach iterations, variable lookups and function calls")
+	flag.BoolVar(&a.opts.KeepGoing, "keep-going", false, "render every file in dir mode even if some fail, failed files are not written and reported at the end")
+	flag.BoolVar(&a.opts.Transactional, "transactional", false, "render the whole output directory into a staging directory and only replace the output directory if every file succeeded. Files of the output directory which are not rendered are kept")
+	flag.IntVar(&a.opts.Jobs, "jobs", runtime.NumCPU(), "the amount of files rendered concurrently in dir mode")
+	flag.BoolVar(&a.opts.Incremental, "incremental", false, "skip outputs whose inputs did not change since the last run, tracked by a manifest next to the output")
+	flag.BoolVar(&a.opts.Check, "check", false, "compare the rendered templates with the existing outputs instead of writing them")
+	flag.BoolVar(&a.opts.Watch, "watch", false, "watch the input, imports and var files and re-render affected outputs on change. Cannot be combined with -check, -incremental or -transactional")
+	flag.StringVar(&a.opts.InPath, "in", "", "the root path. Use - to read the template from stdin")
+	flag.StringVar(&a.opts.OutPath, "out", "", "the output path. Use - to write to stdout. If not used, in will be overwritten")
+	flag.Var(&varFilePaths, "var", "the optional var file path. YAML, JSON, TOML and .env files are detected by their extension or a format prefix, e.g. yaml:vars.txt")
+	flag.Var(&sets, "set", "set the global variable name=value, overriding the var files. Can be used multiple times")
+	flag.Var(&setFiles, "set-file", "set the global variable name=path to the content of the file at path, e.g. for multi-line values. Can be used multiple times")
+	flag.Var(&prefixes, "prefix", "the directive prefix, e.g. \"# yatt\". Can be used multiple times, defaults to #yatt, # yatt, //yatt and // yatt")
+	flag.StringVar(&a.opts.TemplateStart, "template-start", "", "the delimiter which starts variables and functions. Defaults to {{")
+	flag.StringVar(&a.opts.TemplateEnd, "template-end", "", "the delimiter which ends variables and functions. Defaults to }}")
+	flag.StringVar(&a.opts.LineEnding, "line-ending", "", "the line ending of the outputs, one of lf, crlf or auto. auto keeps the line ending of every input file. Defaults to the OS line ending")
+	flag.BoolVar(&crlf, "crlf", false, "split and join contents by CRLF, shorthand for -line-ending crlf")
+	flag.StringVar(&a.opts.Diagnostics, "diagnostics", diagnostic.FormatText, "the format of reported errors, either text or json. json writes one diagnostic per line to stderr")
+	flag.StringVar(&a.depsFormat, "deps-format", interpreter.DepsFormatTree, "the format of the deps command, one of tree, dot or json")
+	flag.StringVar(&a.reverse, "reverse", "", "only print the templates which import the given partial, directly or transitively, and their outputs if -out is set (deps command)")
+	flag.StringVar(&a.configPath, "config", "", "the config file path. Defaults to yatt.yaml or .yattrc inside the working directory")
+	flag.Var(&a.targets, "target", "the name of the config target to render. Can be used multiple times, defaults to all targets")
+	// The command line is parsed with flag.ExitOnError, errors are never returned.
+	_ = flag.CommandLine.Parse(args)
+
+	a.opts.FileBlacklist = fileBlackList
+	a.opts.FileWhitelist = fileWhiteList
+	a.opts.VarFilePaths = varFilePaths
+	a.opts.Set = sets
+	a.opts.SetFiles = setFiles
+	a.opts.Prefixes = prefixes
+
+	a.explicit = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		a.explicit[f.Name] = true
+	})
+	if crlf && !a.explicit["line-ending"] {
+		a.opts.LineEnding = interpreter.LineEndingCRLF
+		a.explicit["line-ending"] = true
+	}
 	return
 }
 
-func main() {
-	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+// resolveTargets merges the config file, if there is any, with the CLI options.
+func resolveTargets(a cliArgs) (targets []config.NamedOptions, err error) {
+	path := a.configPath
+	if path == "" {
+		path, err = config.Find(".")
+		if err != nil {
+			return
+		}
+	}
+	if path == "" {
+		if len(a.targets) > 0 {
+			return nil, errors.New("targets require a config file")
+		}
+		return []config.NamedOptions{{Options: a.opts}}, nil
+	}
 
-	if len(os.Args) == 1 {
-		l.Error().Msg("invalid syntax: yatt <path> [options]")
+	cfg, err := config.Load(path)
+	if err != nil {
+		return
+	}
+	return cfg.Resolve(a.opts, func(flag string) bool {
+		return a.explicit[flag]
+	}, a.targets...)
+}
+
+// prepareOptions validates and completes the options of a single target.
+func prepareOptions(l zerolog.Logger, command string, opts *interpreter.Options) {
+	if command == commandLint || command == commandDeps {
+		// Linting and printing the dependencies do not write any output.
+		if opts.InPath == "" {
+			l.Fatal().Msg("in path needs to be defined")
+		}
+		opts.InPath = filepath.Clean(opts.InPath)
 		return
 	}
 
-	opts := parseFlags()
+	if opts.OutPath == "" && opts.InPath == stdioPath {
+		// Reading from stdin cannot overwrite the input, write to stdout instead.
+		opts.OutPath = stdioPath
+	}
+	if opts.OutPath == "" && opts.Check {
+		l.Fatal().Msg("out path needs to be defined in check mode")
+	}
 	if opts.OutPath == "" {
 		r := bufio.NewReader(os.Stdin)
 		fmt.Printf("Are you sure that you want to overwrite %s? [y/N] ", opts.InPath)
@@ -74,16 +188,113 @@ func main() {
 
 	opts.InPath = filepath.Clean(opts.InPath)
 	opts.OutPath = filepath.Clean(opts.OutPath)
+}
+
+// fatal reports err in the configured diagnostics format and exits.
+func fatal(l zerolog.Logger, opts interpreter.Options, err error, msg string) {
+	if opts.Diagnostics != diagnostic.FormatJSON {
+		l.Fatal().Err(err).Msg(msg)
+	}
+
+	wErr := diagnostic.WriteJSON(os.Stderr, err)
+	if wErr != nil {
+		l.Err(wErr).Msg("unable to write diagnostics")
+	}
+	os.Exit(1)
+}
+
+// blame prints the template line which produced the output line referenced by args, e.g. "out.conf:123".
+func blame(l zerolog.Logger, args []string) {
+	if len(args) != 1 {
+		l.Fatal().Msg("invalid syntax: yatt blame <output path>:<line>")
+	}
+
+	mp, err := sourcemap.Blame(args[0])
+	if err != nil {
+		l.Fatal().Err(err).Msg("unable to blame")
+	}
+	fmt.Println(mp)
+}
+
+func main() {
+	l := log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	if len(os.Args) > 1 && os.Args[1] == commandBlame {
+		blame(l, os.Args[2:])
+		return
+	}
+
+	args := parseFlags()
+	targets, err := resolveTargets(args)
+	if err != nil {
+		l.Fatal().Err(err).Msg("unable to load config")
+	}
+	if len(targets) == 1 && targets[0].Options.InPath == "" && len(os.Args) == 1 {
+		l.Error().Msg("invalid syntax: yatt <path> [options]")
+		return
+	}
 
 	logLvl := zerolog.InfoLevel
-	if opts.Verbose {
-		logLvl = zerolog.DebugLevel
+	for i := range targets {
+		prepareOptions(l, args.command, &targets[i].Options)
+		if targets[i].Options.Verbose && logLvl > zerolog.DebugLevel {
+			logLvl = zerolog.DebugLevel
+		}
+		if targets[i].Options.Trace {
+			logLvl = zerolog.TraceLevel
+		}
 	}
 	zerolog.SetGlobalLevel(logLvl)
 
-	ip := interpreter.New(l, &opts)
-	err := ip.Start()
-	if err != nil {
-		l.Fatal().Err(err).Msg("error upon execution")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Watching targets block, run them concurrently.
+	wg := sync.WaitGroup{}
+	for _, t := range targets {
+		tl := l
+		if t.Name != "" {
+			tl = l.With().Str("target", t.Name).Logger()
+		}
+
+		ip, err := interpreter.New(tl, &t.Options)
+		if err != nil {
+			tl.Fatal().Err(err).Msg("unable to initialize")
+		}
+		if args.command == commandDeps {
+			err = ip.Deps(args.depsFormat, args.reverse)
+			if err != nil {
+				fatal(tl, t.Options, err, "unable to print dependencies")
+			}
+			continue
+		}
+		if args.command == commandLint {
+			err = ip.Lint()
+			if errors.Is(err, interpreter.ErrLint) && t.Options.Diagnostics == diagnostic.FormatJSON {
+				// The diagnostics have already been written.
+				os.Exit(1)
+			}
+			if err != nil {
+				fatal(tl, t.Options, err, "lint failed")
+			}
+			continue
+		}
+		if t.Options.Watch {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				err := ip.Watch(ctx)
+				if err != nil {
+					fatal(tl, t.Options, err, "error upon watching")
+				}
+			}()
+			continue
+		}
+
+		err = ip.Start()
+		if err != nil {
+			fatal(tl, t.Options, err, "error upon execution")
+		}
 	}
+	wg.Wait()
 }
@@ -0,0 +1,184 @@
+// Package yatt exposes the yatt templating engine for embedding it into Go programs.
+package yatt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"github.com/xiroxasx/yatt/internal/common"
+	"github.com/xiroxasx/yatt/internal/core"
+)
+
+// StreamName is the file name used for templates rendered via Engine.Render.
+const StreamName = "-"
+
+const (
+	LineEndingLF   = "\n"
+	LineEndingCRLF = "\r\n"
+)
+
+var ErrInvalidLineEnding = errors.New("line ending must either be LF or CRLF")
+
+// Engine renders yatt templates.
+// Variables set on the engine are shared between renders,
+// every other state, e.g. local variables, starts off empty for every render.
+// An Engine must not be used concurrently.
+type Engine struct {
+	core *core.Core
+	opts Options
+}
+
+type Options struct {
+	// Prefixes are the directive prefixes, e.g. "# yatt".
+	// Defaults to DefaultPrefixes.
+	Prefixes []string
+	// TemplateStart and TemplateEnd delimit variables and functions.
+	// Default to "{{" and "}}".
+	TemplateStart string
+	TemplateEnd   string
+	// PreserveIndent applies the indent of import statements to the imported content.
+	PreserveIndent bool
+	// Strict makes unresolved variables an error instead of an empty value.
+	// The error contains the file, line and similar variable names.
+	Strict bool
+	// Passthrough emits tokens which are neither a known variable nor a known function verbatim,
+	// delimiters included, so that yatt can be layered on top of other template languages.
+	Passthrough bool
+	// Trace writes every evaluation step, e.g. taken branches, variable lookups and function calls,
+	// to the Logger at trace level.
+	Trace bool
+	// LineEnding is either LineEndingLF or LineEndingCRLF.
+	// Defaults to the line ending of the current OS.
+	LineEnding string
+	// Logger is used for non-fatal messages.
+	// Defaults to a disabled logger.
+	Logger *zerolog.Logger
+}
+
+// DefaultPrefixes returns the directive prefixes used by the yatt CLI.
+func DefaultPrefixes() []string {
+	return core.DefaultPrefixes()
+}
+
+// New creates a new Engine with the given options.
+func New(opts Options) (e *Engine, err error) {
+	if len(opts.Prefixes) == 0 {
+		opts.Prefixes = DefaultPrefixes()
+	}
+	switch opts.LineEnding {
+	case "":
+		opts.LineEnding = string(common.LineEnding())
+	case LineEndingLF, LineEndingCRLF:
+	default:
+		return nil, ErrInvalidLineEnding
+	}
+
+	coreOpts := core.Options{
+		PreserveIndent: opts.PreserveIndent,
+		LineEnding:     []byte(opts.LineEnding),
+		TemplateStart:  []byte(opts.TemplateStart),
+		TemplateEnd:    []byte(opts.TemplateEnd),
+		Strict:         opts.Strict,
+		Passthrough:    opts.Passthrough,
+		Trace:          opts.Trace,
+	}
+	err = core.Validate(opts.Prefixes, coreOpts)
+	if err != nil {
+		return
+	}
+
+	l := zerolog.Nop()
+	if opts.Logger != nil {
+		l = *opts.Logger
+	}
+
+	e = &Engine{
+		opts: opts,
+		core: core.New(l, opts.Prefixes, coreOpts),
+	}
+	return
+}
+
+// LoadVarFiles reads the global variables declared in the given var files.
+// YAML, JSON, TOML and .env files are detected by their extension or a "<format>:" prefix, e.g. "yaml:vars.txt".
+func (e *Engine) LoadVarFiles(paths ...string) (err error) {
+	cleaned := make([]string, len(paths))
+	for i, p := range paths {
+		cleaned[i] = filepath.Clean(p)
+	}
+	return e.core.InitGlobalVariablesByFiles(cleaned...)
+}
+
+// SetGlobal sets a global variable which can be used by every rendered template.
+func (e *Engine) SetGlobal(name, value string) (err error) {
+	return e.core.SetGlobalVariable(name, value)
+}
+
+// SetGlobals sets all given global variables.
+func (e *Engine) SetGlobals(vars map[string]string) (err error) {
+	for name, value := range vars {
+		err = e.SetGlobal(name, value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return
+}
+
+// Render reads the template from r and writes the result to w.
+// Imports are resolved relative to the working directory.
+func (e *Engine) Render(r io.Reader, w io.Writer) (err error) {
+	// The template needs to be read twice, once for the dependency check and once for the interpretation.
+	in, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	c := e.core.Fork()
+	err = c.CheckCyclicDependencies(StreamName, bytes.NewReader(in))
+	if err != nil {
+		return fmt.Errorf("dependency check: %v", err)
+	}
+
+	return e.render(c, StreamName, io.NopCloser(bytes.NewReader(in)), w)
+}
+
+// RenderFile renders the template at path and writes the result to w.
+func (e *Engine) RenderFile(path string, w io.Writer) (err error) {
+	path = filepath.Clean(path)
+	c := e.core.Fork()
+	err = c.ImportPathCheckCyclicDependencies(path)
+	if err != nil {
+		return fmt.Errorf("dependency check: %v", err)
+	}
+
+	// The interpret method will close the file afterwards.
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	return e.render(c, path, f, w)
+}
+
+// render interprets rc with c, which needs to be forked off the engine's core,
+// so that the states of a render are not carried over to the next one.
+func (e *Engine) render(c *core.Core, name string, rc io.ReadCloser, w io.Writer) (err error) {
+	buf := &bytes.Buffer{}
+	err = c.Interpret(core.InterpreterFile{
+		Name: name,
+		RC:   rc,
+		Buf:  buf,
+	})
+	if err != nil {
+		return
+	}
+
+	// Cut the last line ending, just like the CLI does.
+	_, err = w.Write(bytes.TrimSuffix(buf.Bytes(), []byte(e.opts.LineEnding)))
+	return
+}
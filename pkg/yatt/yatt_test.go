@@ -0,0 +1,84 @@
+package yatt
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	r "github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+
+	e, err := New(Options{LineEnding: LineEndingLF})
+	r.NoError(t, err)
+	r.NoError(t, e.SetGlobals(map[string]string{"name": "Gopher"}))
+
+	buf := &bytes.Buffer{}
+	err = e.Render(strings.NewReader("# yatt var count = 2\n{{name}} has {{add(count, 1)}} templates\n"), buf)
+	r.NoError(t, err)
+	r.Exactly(t, "Gopher has 3 templates", buf.String())
+}
+
+func TestRenderFile(t *testing.T) {
+	t.Parallel()
+
+	e, err := New(Options{LineEnding: LineEndingLF})
+	r.NoError(t, err)
+	r.NoError(t, e.LoadVarFiles(filepath.Join("testdata", "yatt.var")))
+
+	buf := &bytes.Buffer{}
+	r.NoError(t, e.RenderFile(filepath.Join("testdata", "greeting.txt"), buf))
+	r.Exactly(t, "Hello, World!", buf.String())
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(Options{LineEnding: "\r"})
+	r.ErrorIs(t, err, ErrInvalidLineEnding)
+
+	e, err := New(Options{})
+	r.NoError(t, err)
+	r.Error(t, e.SetGlobal("", "value"))
+	r.Error(t, e.LoadVarFiles(filepath.Join("testdata", "missing.var")))
+	r.Error(t, e.RenderFile(filepath.Join("testdata", "missing.txt"), &bytes.Buffer{}))
+	r.Error(t, e.Render(strings.NewReader("# yatt unknown\n"), &bytes.Buffer{}))
+}
+
+func TestStrict(t *testing.T) {
+	t.Parallel()
+
+	e, err := New(Options{LineEnding: LineEndingLF, Strict: true})
+	r.NoError(t, err)
+	r.NoError(t, e.SetGlobal("host", "localhost"))
+
+	buf := &bytes.Buffer{}
+	err = e.Render(strings.NewReader("{{host}}\n{{hsot}}\n"), buf)
+	r.ErrorContains(t, err, `-:2:1: unresolved variable: "hsot", did you mean "host"?`)
+}
+
+func TestRenderIsolation(t *testing.T) {
+	t.Parallel()
+
+	e, err := New(Options{LineEnding: LineEndingLF})
+	r.NoError(t, err)
+	r.NoError(t, e.SetGlobal("name", "Gopher"))
+
+	// Local variables of a render are not carried over to the next one.
+	buf := &bytes.Buffer{}
+	r.NoError(t, e.Render(strings.NewReader("# yatt var local = 1\n{{name}} {{local}}\n"), buf))
+	r.Exactly(t, "Gopher 1", buf.String())
+	buf.Reset()
+	r.NoError(t, e.Render(strings.NewReader("{{name}} {{local}}\n"), buf))
+	r.Exactly(t, "Gopher ", buf.String())
+
+	// A failed render does not affect the following ones.
+	err = e.Render(strings.NewReader("# yatt if {{name}} == Gopher\nunclosed\n"), &bytes.Buffer{})
+	r.ErrorContains(t, err, "unclosed")
+	buf.Reset()
+	r.NoError(t, e.Render(strings.NewReader("{{name}}\n"), buf))
+	r.Exactly(t, "Gopher", buf.String())
+}